@@ -0,0 +1,31 @@
+package apierror
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handler is the gateway's Fiber error handler. Handlers and middleware
+// return an *Error, or any other error, and Handler answers with the error
+// envelope and the request's ID. Errors that are not an *Error or a
+// *fiber.Error are logged and hidden behind an internal error.
+func Handler(c *fiber.Ctx, err error) error {
+	apiErr, ok := As(err)
+	if !ok {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			apiErr = FromStatus(fiberErr.Code, fiberErr.Message)
+		} else {
+			log.Printf("Error handling %s %s: %v", c.Method(), c.Path(), err)
+			apiErr = New(CodeInternal, "Internal error")
+		}
+	}
+
+	response := *apiErr
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		response.RequestID = requestID
+	}
+	return c.Status(response.Status()).JSON(fiber.Map{"error": response})
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"api-gateway/apierror"
+	"api-gateway/middleware"
 	"api-gateway/routes"
+	"api-gateway/uploads"
 	"api-gateway/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/joho/godotenv"
 )
 
@@ -21,21 +28,55 @@ func main() {
 	utils.InitFirebase()
 	defer utils.CloseFirestore()
 
-	utils.InitKafkaConsumer()
+	// Stage resumable uploads until they are complete
+	uploads.InitStore()
+
+	// Pick how bearer tokens are verified
+	middleware.InitTokenVerifier()
+	middleware.InitRateLimiter()
+	middleware.InitIdempotency()
+
+	utils.InitProducer()
+	defer utils.CloseProducer()
+
+	utils.InitKafkaConsumer(routes.ReplyTopics(routes.Definitions))
 
 	// Create a new Fiber instance
-	app := fiber.New()
+	// Behind a load balancer the client's address is in a header such as
+	// X-Forwarded-For; per-IP rate limits need it. Bodies may hold a whole
+	// upload, which is larger than Fiber's default limit.
+	app := fiber.New(fiber.Config{
+		ProxyHeader:  os.Getenv("PROXY_HEADER"),
+		ErrorHandler: apierror.Handler,
+		BodyLimit:    uploads.BodyLimit(),
+	})
+
+	// Tag every request with an ID, echoed in X-Request-ID and in errors
+	app.Use(requestid.New())
 
 	// Set up CORS
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:5173, https://9b7aa3157677.ngrok.app",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, Idempotency-Key, Last-Event-ID, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset",
+		ExposeHeaders:    "Location, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Idempotent-Replayed, X-Request-ID, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Max-Chunk-Size, Upload-Offset, Upload-Length, Upload-Expires, Upload-Metadata, X-Job-ID",
 		AllowCredentials: true,
 	}))
 
 	// Set up routes
 	routes.SetupRoutes(app)
 
+	// Shut down cleanly on SIGINT/SIGTERM so queued Kafka messages are flushed
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	// Start the server
-	log.Fatal(app.Listen(":4001"))
+	if err := app.Listen(":4001"); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
 }
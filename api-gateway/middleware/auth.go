@@ -4,33 +4,36 @@ import (
 	"log"
 	"strings"
 
-	"api-gateway/utils"
+	"api-gateway/apierror"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// AuthRequired verifies the bearer token with Verifier and stores its claims
+// in c.Locals("claims") and the user's UID in c.Locals("user_id").
 func AuthRequired() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
 			log.Println("No header")
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error":"missing or invalid token"})
+			return apierror.New(apierror.CodeUnauthenticated, "Missing or invalid token")
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			log.Println("bad header")
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error":"missing or invalid token"})
+			return apierror.New(apierror.CodeUnauthenticated, "Missing or invalid token")
 		}
 
 		tokenString := parts[1]
 
-		claims, err := utils.ValidateToken(tokenString)
+		claims, err := Verifier.Verify(c.UserContext(), tokenString)
 		if err != nil {
 			log.Println("Invalid or expired")
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error":"invalid or expired token"})
+			return apierror.New(apierror.CodeUnauthenticated, "Invalid or expired token")
 		}
 
+		c.Locals("claims", claims)
 		c.Locals("user_id", claims.UID)
 		c.Set("X-User-ID", claims.UID)
 		return c.Next()
@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyRecord is what is kept for an Idempotency-Key: the request it was
+// first used with and, once that request has finished, its response.
+type IdempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Done        bool   `json:"done"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps idempotency records.
+type IdempotencyStore interface {
+	// Reserve claims key for a request with fingerprint until ttl passes. If
+	// the key is already taken it returns the existing record and false.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	// Save stores the finished request's record under key for ttl.
+	Save(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release frees key so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+var (
+	// Idempotency is the store used by Idempotent, set up by InitIdempotency.
+	Idempotency IdempotencyStore
+	// IdempotencyTTL is how long a finished response is replayed for.
+	IdempotencyTTL = 24 * time.Hour
+)
+
+// pendingTTL bounds how long a key stays reserved by a request that never
+// finishes, for example because the gateway restarted, and how long it stays
+// reserved after a request times out.
+const pendingTTL = time.Minute
+
+const maxIdempotencyKeyLength = 255
+
+// InitIdempotency selects the store named by IDEMPOTENCY_STORE, memory (the
+// default) or redis, and reads IDEMPOTENCY_TTL. Like the rate limiter, only
+// the redis store is shared between gateway replicas.
+func InitIdempotency() {
+	if ttl := os.Getenv("IDEMPOTENCY_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("Invalid IDEMPOTENCY_TTL %q", ttl)
+		}
+		IdempotencyTTL = parsed
+	}
+
+	switch os.Getenv("IDEMPOTENCY_STORE") {
+	case "", "memory":
+		Idempotency = NewMemoryIdempotencyStore()
+	case "redis":
+		client, err := RedisClient()
+		if err != nil {
+			log.Fatalf("Error initializing idempotency store: %v", err)
+		}
+		Idempotency = NewRedisIdempotencyStore(client)
+	default:
+		log.Fatalf("Unknown IDEMPOTENCY_STORE %q", os.Getenv("IDEMPOTENCY_STORE"))
+	}
+}
+
+// Idempotent makes a route safe to retry. The first request with a given
+// Idempotency-Key header runs normally and its response is kept per user and
+// key; later requests with the same key get that response replayed, marked
+// with Idempotent-Replayed, without running the route again. Reusing a key for
+// a different request is a 409, as is retrying while the first request is
+// still running, or for pendingTTL after it timed out. Other server errors
+// and 429s are not kept, so those can be retried with the same key. Requests
+// without the header are not affected. It must run after AuthRequired.
+func Idempotent() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		idempotencyKey := c.Get("Idempotency-Key")
+		uid, _ := c.Locals("user_id").(string)
+		if idempotencyKey == "" || uid == "" {
+			return c.Next()
+		}
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			return apierror.New(apierror.CodeInvalidRequest, "Idempotency-Key is too long")
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			return apierror.New(apierror.CodeInvalidRequest, "Invalid request body")
+		}
+
+		key := "idempotency:" + uid + ":" + idempotencyKey
+		record, reserved, err := Idempotency.Reserve(c.UserContext(), key, fingerprint, pendingTTL)
+		if err != nil {
+			log.Printf("Error reserving idempotency key %s: %v", key, err)
+			return c.Next()
+		}
+		if !reserved {
+			return replayResponse(c, record, fingerprint)
+		}
+
+		// Errors are rendered here rather than by the app, so that the
+		// response can be kept like any other.
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				releaseKey(key)
+				return err
+			}
+		}
+
+		status := c.Response().StatusCode()
+		if status == fiber.StatusGatewayTimeout {
+			// The service may still act on a request that timed out, so the
+			// key stays in progress for a while rather than inviting a second
+			// attempt that could run alongside it.
+			pending := &IdempotencyRecord{Fingerprint: fingerprint}
+			if err := Idempotency.Save(context.Background(), key, pending, pendingTTL); err != nil {
+				log.Printf("Error holding idempotency key %s: %v", key, err)
+			}
+			return nil
+		}
+		if status >= fiber.StatusInternalServerError || status == fiber.StatusTooManyRequests {
+			releaseKey(key)
+			return nil
+		}
+		record = &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Done:        true,
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Location:    string(c.Response().Header.Peek(fiber.HeaderLocation)),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		}
+		if err := Idempotency.Save(context.Background(), key, record, IdempotencyTTL); err != nil {
+			log.Printf("Error saving idempotent response %s: %v", key, err)
+		}
+		return nil
+	}
+}
+
+func replayResponse(c *fiber.Ctx, record *IdempotencyRecord, fingerprint string) error {
+	if record.Fingerprint != fingerprint {
+		return apierror.New(apierror.CodeConflict, "Idempotency-Key was already used for a different request")
+	}
+	if !record.Done {
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return apierror.New(apierror.CodeConflict, "A request with this Idempotency-Key is still in progress")
+	}
+
+	c.Set("Idempotent-Replayed", "true")
+	if record.ContentType != "" {
+		c.Set(fiber.HeaderContentType, record.ContentType)
+	}
+	if record.Location != "" {
+		c.Set(fiber.HeaderLocation, record.Location)
+	}
+	return c.Status(record.StatusCode).Send(record.Body)
+}
+
+func releaseKey(key string) {
+	if err := Idempotency.Release(context.Background(), key); err != nil {
+		log.Printf("Error releasing idempotency key %s: %v", key, err)
+	}
+}
+
+// requestFingerprint hashes the method, path and body. Multipart bodies are
+// hashed by their fields and file contents, because clients pick a new
+// boundary for every attempt.
+func requestFingerprint(c *fiber.Ctx) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, c.Method()+" "+c.Path()+"\n")
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		hash.Write(c.Body())
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(form.Value))
+	for name := range form.Value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		io.WriteString(hash, "value "+name+"\n")
+		for _, value := range form.Value[name] {
+			io.WriteString(hash, value+"\n")
+		}
+	}
+
+	names = names[:0]
+	for name := range form.File {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, header := range form.File[name] {
+			io.WriteString(hash, "file "+name+" "+header.Filename+"\n")
+			file, err := header.Open()
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(hash, file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type idempotencyEntry struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+// MemoryIdempotencyStore keeps records in memory.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	store := &MemoryIdempotencyStore{entries: make(map[string]idempotencyEntry)}
+	go store.prune()
+	return store
+}
+
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := s.entries[key]; exists && now.Before(entry.expires) {
+		return entry.record, false, nil
+	}
+	s.entries[key] = idempotencyEntry{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = idempotencyEntry{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) prune() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if now.After(entry.expires) {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisIdempotencyStore keeps records in Redis as JSON.
+type RedisIdempotencyStore struct {
+	client *redis.Client
+}
+
+func NewRedisIdempotencyStore(client *redis.Client) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client}
+}
+
+func (s *RedisIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	pending, err := json.Marshal(&IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, false, err
+	}
+	reserved, err := s.client.SetNX(ctx, key, pending, ttl).Result()
+	if err != nil || reserved {
+		return nil, reserved, err
+	}
+
+	data, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The record expired in between; try again.
+		return s.Reserve(ctx, key, fingerprint, ttl)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	record := &IdempotencyRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, false, err
+	}
+	return record, false, nil
+}
+
+func (s *RedisIdempotencyStore) Save(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIdempotentKeepsKeysAfterTimeouts(t *testing.T) {
+	previous := Idempotency
+	defer func() { Idempotency = previous }()
+	Idempotency = &MemoryIdempotencyStore{entries: make(map[string]idempotencyEntry)}
+
+	statuses := map[string]int{}
+	calls := map[string]int{}
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Post("/:name", func(c *fiber.Ctx) error {
+		c.Locals("user_id", "user-1")
+		return c.Next()
+	}, Idempotent(), func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		calls[name]++
+		if status := statuses[name]; status != 0 {
+			return apierror.FromStatus(status, "")
+		}
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	post := func(name string) int {
+		req := httptest.NewRequest("POST", "/"+name, strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key-"+name)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name      string
+		status    int
+		wantRetry int
+		wantCalls int
+	}{
+		{"created", 0, fiber.StatusCreated, 1},
+		{"timeout", fiber.StatusGatewayTimeout, fiber.StatusConflict, 1},
+		{"unavailable", fiber.StatusServiceUnavailable, fiber.StatusServiceUnavailable, 2},
+		{"rate-limited", fiber.StatusTooManyRequests, fiber.StatusTooManyRequests, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statuses[test.name] = test.status
+			post(test.name)
+			if status := post(test.name); status != test.wantRetry {
+				t.Errorf("retry status = %d, want %d", status, test.wantRetry)
+			}
+			if calls[test.name] != test.wantCalls {
+				t.Errorf("route ran %d times, want %d", calls[test.name], test.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// LimitKey says what a rate limit is counted against.
+type LimitKey string
+
+const (
+	// ByUser counts requests per authenticated user. Requests without a user
+	// are counted by IP instead.
+	ByUser LimitKey = "user"
+	ByIP   LimitKey = "ip"
+)
+
+// Limit is a token bucket that holds Burst tokens and refills Requests tokens
+// every Per. Each request takes one token.
+type Limit struct {
+	By       LimitKey
+	Requests int
+	Per      time.Duration
+	// Burst defaults to Requests.
+	Burst int
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// rate is the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// LimitResult is the state of a bucket after a request has tried to take a token.
+type LimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available when not Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps token buckets.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (LimitResult, error)
+}
+
+// RateLimiter is the store used by RateLimit, set up by InitRateLimiter.
+var RateLimiter RateLimitStore
+
+// InitRateLimiter selects the store named by RATE_LIMIT_STORE. memory (the
+// default) keeps buckets in this process, so each gateway replica enforces its
+// own limits; redis shares them between replicas through the server at
+// REDIS_URL.
+func InitRateLimiter() {
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		RateLimiter = NewMemoryRateLimitStore()
+	case "redis":
+		client, err := RedisClient()
+		if err != nil {
+			log.Fatalf("Error initializing rate limit store: %v", err)
+		}
+		RateLimiter = NewRedisRateLimitStore(client)
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q", os.Getenv("RATE_LIMIT_STORE"))
+	}
+}
+
+// RateLimit takes a token from each of the route's limits and answers 429 when
+// any bucket is empty. Each limit has its own bucket, even when two count
+// against the same subject. The RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers describe the limit closest to running out. Put it
+// after AuthRequired so ByUser limits can see the user. If the store fails the
+// request is let through.
+func RateLimit(route string, limits ...Limit) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var tightest *LimitResult
+		var tightestLimit Limit
+		for i, limit := range limits {
+			key := "ratelimit:" + route + ":" + strconv.Itoa(i) + ":" + limitSubject(c, limit.By)
+			result, err := RateLimiter.Take(c.UserContext(), key, limit)
+			if err != nil {
+				log.Printf("Error checking rate limit %s: %v", key, err)
+				continue
+			}
+			if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+				tightest, tightestLimit = &result, limit
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+		if tightest == nil {
+			return c.Next()
+		}
+
+		c.Set("RateLimit-Limit", strconv.Itoa(tightestLimit.burst()))
+		c.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+		if !tightest.Allowed {
+			c.Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+			return apierror.New(apierror.CodeRateLimited, "Too many requests")
+		}
+		return c.Next()
+	}
+}
+
+func limitSubject(c *fiber.Ctx, by LimitKey) string {
+	if by == ByUser {
+		if uid, ok := c.Locals("user_id").(string); ok && uid != "" {
+			return "user:" + uid
+		}
+	}
+	return "ip:" + c.IP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// takeToken refills a bucket holding tokens that was last updated elapsed ago
+// and takes one token from it. It returns the new token count.
+func takeToken(tokens float64, elapsed time.Duration, limit Limit) (float64, LimitResult) {
+	rate := limit.rate()
+	burst := float64(limit.burst())
+	tokens = math.Min(burst, tokens+elapsed.Seconds()*rate)
+
+	result := LimitResult{}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((burst - tokens) / rate * float64(time.Second))
+	return tokens, result
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled, after which it can be dropped.
+	full time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in memory.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+	go store.prune()
+	return store
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit Limit) (LimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.burst()), updated: now}
+		s.buckets[key] = b
+	}
+	var result LimitResult
+	b.tokens, result = takeToken(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// prune drops full buckets, which are the same as missing ones.
+func (s *MemoryRateLimitStore) prune() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// takeTokenScript is takeToken run atomically in Redis. The bucket is a hash
+// of its token count and last update time in milliseconds, and expires once it
+// would be full again.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimitStore keeps buckets in Redis so every gateway replica shares them.
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit Limit) (LimitResult, error) {
+	rate := limit.rate()
+	reply, err := takeTokenScript.Run(ctx, s.client, []string{key}, rate, limit.burst(), time.Now().UnixMilli()).Slice()
+	if err != nil {
+		return LimitResult{}, err
+	}
+	if len(reply) != 2 {
+		return LimitResult{}, fmt.Errorf("unexpected reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	text, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return LimitResult{}, fmt.Errorf("unexpected token count %q", text)
+	}
+
+	result := LimitResult{
+		Allowed:   allowed == 1,
+		Remaining: int(tokens),
+		Reset:     time.Duration((float64(limit.burst()) - tokens) / rate * float64(time.Second)),
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result, nil
+}
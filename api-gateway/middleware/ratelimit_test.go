@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTakeToken(t *testing.T) {
+	// Three tokens, refilled at one per second.
+	limit := Limit{Requests: 60, Per: time.Minute, Burst: 3}
+	tokens := float64(limit.burst())
+
+	var result LimitResult
+	for i := 0; i < 3; i++ {
+		tokens, result = takeToken(tokens, 0, limit)
+		if !result.Allowed {
+			t.Fatalf("request %d denied with a full bucket", i+1)
+		}
+		if result.Remaining != 2-i {
+			t.Errorf("request %d: Remaining = %d, want %d", i+1, result.Remaining, 2-i)
+		}
+	}
+
+	tokens, result = takeToken(tokens, 0, limit)
+	if result.Allowed {
+		t.Fatal("request allowed with an empty bucket")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", result.RetryAfter)
+	}
+	if result.Reset != 3*time.Second {
+		t.Errorf("Reset = %v, want 3s", result.Reset)
+	}
+
+	tokens, result = takeToken(tokens, 500*time.Millisecond, limit)
+	if result.Allowed {
+		t.Fatal("request allowed after half a token refilled")
+	}
+	if result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 500ms", result.RetryAfter)
+	}
+
+	tokens, result = takeToken(tokens, 500*time.Millisecond, limit)
+	if !result.Allowed {
+		t.Fatal("request denied after a token refilled")
+	}
+
+	// A long pause refills the bucket, but never past its burst.
+	_, result = takeToken(tokens, time.Hour, limit)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("after an hour: Allowed = %v, Remaining = %d, want true, 2", result.Allowed, result.Remaining)
+	}
+}
+
+func TestLimitBurstDefaultsToRequests(t *testing.T) {
+	if burst := (Limit{Requests: 10, Per: time.Minute}).burst(); burst != 10 {
+		t.Errorf("burst() = %d, want 10", burst)
+	}
+}
+
+func TestMemoryRateLimitStoreKeepsBucketsApart(t *testing.T) {
+	store := &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+	limit := Limit{Requests: 1, Per: time.Hour}
+	ctx := context.Background()
+
+	if result, _ := store.Take(ctx, "a", limit); !result.Allowed {
+		t.Fatal("first request for a denied")
+	}
+	if result, _ := store.Take(ctx, "a", limit); result.Allowed {
+		t.Fatal("second request for a allowed")
+	}
+	if result, _ := store.Take(ctx, "b", limit); !result.Allowed {
+		t.Fatal("first request for b denied")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	previous := RateLimiter
+	defer func() { RateLimiter = previous }()
+	RateLimiter = &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Get("/", RateLimit("test",
+		Limit{By: ByIP, Requests: 2, Per: time.Hour},
+		Limit{By: ByIP, Requests: 100, Per: time.Hour},
+	), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for i, want := range []int{fiber.StatusOK, fiber.StatusOK, fiber.StatusTooManyRequests} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("request %d: status = %d, want %d", i+1, resp.StatusCode, want)
+		}
+		// The tighter limit is the one reported.
+		if limit := resp.Header.Get("RateLimit-Limit"); limit != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i+1, limit)
+		}
+		if want == fiber.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+}
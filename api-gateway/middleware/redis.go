@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisOnce   sync.Once
+	redisClient *redis.Client
+	redisErr    error
+)
+
+// RedisClient returns the gateway's connection to the Redis server at
+// REDIS_URL, connecting on first use. The stores that share state between
+// gateway replicas all use it.
+func RedisClient() (*redis.Client, error) {
+	redisOnce.Do(func() {
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			redisErr = fmt.Errorf("REDIS_URL is not set")
+			return
+		}
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			redisErr = err
+			return
+		}
+		client := redis.NewClient(options)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			redisErr = fmt.Errorf("connecting to redis: %w", err)
+			return
+		}
+		redisClient = client
+	})
+	return redisClient, redisErr
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"log"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Roles are kept in the "roles" custom claim of a user's token, set by the
+// auth-service.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+// Permissions name the operations a role may perform.
+const (
+	// PermissionAdminAccess lets a user reach the /api/admin routes.
+	PermissionAdminAccess = "admin:access"
+	// PermissionAssignRoles lets a user change other users' roles.
+	PermissionAssignRoles = "roles:assign"
+	// PermissionModerateScans lets a user review and remove other users' scans.
+	PermissionModerateScans = "scans:moderate"
+	// PermissionManagePlans lets a user change other users' plans.
+	PermissionManagePlans = "plans:manage"
+)
+
+// RolePermissions maps each role to the permissions it grants.
+var RolePermissions = map[string][]string{
+	RoleAdmin:     {PermissionAdminAccess, PermissionAssignRoles, PermissionModerateScans, PermissionManagePlans},
+	RoleModerator: {PermissionAdminAccess, PermissionModerateScans},
+}
+
+// Roles returns the roles in the token's "roles" claim, which may be a list or
+// a single string.
+func (c *Claims) Roles() []string {
+	switch value := c.Values["roles"].(type) {
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	case []interface{}:
+		roles := make([]string, 0, len(value))
+		for _, item := range value {
+			if role, ok := item.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+// HasRole reports whether the token carries role.
+func (c *Claims) HasRole(role string) bool {
+	for _, held := range c.Roles() {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether any of the token's roles grants permission.
+func (c *Claims) HasPermission(permission string) bool {
+	for _, role := range c.Roles() {
+		for _, granted := range RolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireRole lets the request through when the user holds any of roles. It
+// must run after AuthRequired.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*Claims)
+		if ok {
+			for _, role := range roles {
+				if claims.HasRole(role) {
+					return c.Next()
+				}
+			}
+		}
+		return forbidden(c, claims)
+	}
+}
+
+// RequirePermission lets the request through when one of the user's roles
+// grants permission. It must run after AuthRequired.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*Claims)
+		if ok && claims.HasPermission(permission) {
+			return c.Next()
+		}
+		return forbidden(c, claims)
+	}
+}
+
+func forbidden(c *fiber.Ctx, claims *Claims) error {
+	if claims == nil {
+		log.Printf("No claims on %s %s, is AuthRequired missing?", c.Method(), c.Path())
+		return apierror.New(apierror.CodeUnauthenticated, "Missing or invalid token")
+	}
+	log.Printf("User %s is not allowed to %s %s", claims.UID, c.Method(), c.Path())
+	return apierror.New(apierror.CodePermissionDenied, "Insufficient permissions")
+}
@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"api-gateway/utils"
+
+	"firebase.google.com/go/auth"
+)
+
+// Claims are the verified claims of a token. AuthRequired stores them in
+// c.Locals("claims").
+type Claims struct {
+	UID      string `json:"uid"`
+	Email    string `json:"email,omitempty"`
+	Issuer   string `json:"iss,omitempty"`
+	Audience string `json:"aud,omitempty"`
+	IssuedAt int64  `json:"iat,omitempty"`
+	Expires  int64  `json:"exp,omitempty"`
+	// Values holds every claim in the token, including custom claims.
+	Values map[string]interface{} `json:"claims,omitempty"`
+}
+
+// TokenVerifier checks a bearer token and returns its claims.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// Verifier is the token verifier used by AuthRequired, set up by InitTokenVerifier.
+var Verifier TokenVerifier
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// InitTokenVerifier selects the verifier named by AUTH_VERIFIER:
+//   - firebase (the default) verifies Firebase ID tokens.
+//   - jwks verifies RS256 tokens against the keys in JWKS_FILE, checking
+//     JWT_ISSUER and JWT_AUDIENCE when they are set.
+//   - static accepts only the tokens listed in STATIC_TOKENS_FILE, a JSON
+//     object mapping each token to its claims. It is meant for tests and
+//     local development.
+func InitTokenVerifier() {
+	var err error
+	switch os.Getenv("AUTH_VERIFIER") {
+	case "", "firebase":
+		utils.InitFirebaseAuth()
+		Verifier = NewFirebaseVerifier(utils.AuthClient)
+	case "jwks":
+		Verifier, err = NewJWKSVerifier(os.Getenv("JWKS_FILE"), os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+	case "static":
+		log.Println("Using static token verifier, do not use this in production")
+		Verifier, err = NewStaticVerifierFromFile(os.Getenv("STATIC_TOKENS_FILE"))
+	default:
+		err = fmt.Errorf("unknown AUTH_VERIFIER %q", os.Getenv("AUTH_VERIFIER"))
+	}
+	if err != nil {
+		log.Fatalf("Error initializing token verifier: %v", err)
+	}
+}
+
+// FirebaseVerifier verifies Firebase ID tokens.
+type FirebaseVerifier struct {
+	client *auth.Client
+}
+
+func NewFirebaseVerifier(client *auth.Client) *FirebaseVerifier {
+	return &FirebaseVerifier{client: client}
+}
+
+func (v *FirebaseVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	verified, err := v.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		UID:      verified.UID,
+		Issuer:   verified.Issuer,
+		Audience: verified.Audience,
+		IssuedAt: verified.IssuedAt,
+		Expires:  verified.Expires,
+		Values:   verified.Claims,
+	}
+	claims.Email, _ = verified.Claims["email"].(string)
+	return claims, nil
+}
+
+// JWKSVerifier verifies RS256 tokens with public keys read from a JWKS file.
+type JWKSVerifier struct {
+	keys     map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// clockSkew is how far token times may be off from ours.
+const clockSkew = time.Minute
+
+func NewJWKSVerifier(path, issuer, audience string) (*JWKSVerifier, error) {
+	if path == "" {
+		return nil, errors.New("JWKS_FILE is not set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad modulus: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad exponent: %w", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no RSA signing keys", path)
+	}
+
+	return &JWKSVerifier{keys: keys, issuer: issuer, audience: audience, now: time.Now}, nil
+}
+
+func (v *JWKSVerifier) Verify(_ context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+	key, exists := v.keys[header.Kid]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var values map[string]interface{}
+	if err := decodeSegment(parts[1], &values); err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{Values: values}
+	claims.UID, _ = values["sub"].(string)
+	claims.Email, _ = values["email"].(string)
+	claims.Issuer, _ = values["iss"].(string)
+	claims.IssuedAt = numericClaim(values["iat"])
+	claims.Expires = numericClaim(values["exp"])
+
+	now := v.now()
+	if claims.UID == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if claims.Expires == 0 || now.Add(-clockSkew).Unix() >= claims.Expires {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if notBefore := numericClaim(values["nbf"]); notBefore != 0 && now.Add(clockSkew).Unix() < notBefore {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.audience != "" {
+		if !hasAudience(values["aud"], v.audience) {
+			return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+		}
+		claims.Audience = v.audience
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func numericClaim(value interface{}) int64 {
+	number, _ := value.(float64)
+	return int64(number)
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings,
+// contains audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StaticVerifier accepts a fixed set of tokens. It is meant for tests.
+type StaticVerifier struct {
+	tokens map[string]*Claims
+}
+
+func NewStaticVerifier(tokens map[string]*Claims) *StaticVerifier {
+	return &StaticVerifier{tokens: tokens}
+}
+
+func NewStaticVerifierFromFile(path string) (*StaticVerifier, error) {
+	if path == "" {
+		return nil, errors.New("STATIC_TOKENS_FILE is not set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]*Claims)
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewStaticVerifier(tokens), nil
+}
+
+func (v *StaticVerifier) Verify(_ context.Context, token string) (*Claims, error) {
+	claims, exists := v.tokens[token]
+	if !exists || claims.UID == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var testNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeJWKS writes a JWKS file holding the public half of each key, by kid.
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	type jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key, otherKey := generateKey(t), generateKey(t)
+	verifier, err := NewJWKSVerifier(writeJWKS(t, map[string]*rsa.PrivateKey{"main": key}), "https://issuer.example", "facial-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = func() time.Time { return testNow }
+
+	header := map[string]interface{}{"alg": "RS256", "kid": "main"}
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":   "user-1",
+			"email": "user@example.com",
+			"iss":   "https://issuer.example",
+			"aud":   "facial-scan",
+			"iat":   testNow.Add(-time.Minute).Unix(),
+			"exp":   testNow.Add(time.Hour).Unix(),
+		}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	valid := signToken(t, key, header, validClaims())
+	tampered := valid[:len(valid)-4] + "AAAA"
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"audience in a list", signToken(t, key, header, with("aud", []string{"other", "facial-scan"})), false},
+		{"within clock skew", signToken(t, key, header, with("exp", testNow.Add(-30*time.Second).Unix())), false},
+		{"tampered signature", tampered, true},
+		{"signed by another key", signToken(t, otherKey, header, validClaims()), true},
+		{"unknown key", signToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "other"}, validClaims()), true},
+		{"wrong algorithm", signToken(t, key, map[string]interface{}{"alg": "HS256", "kid": "main"}, validClaims()), true},
+		{"wrong issuer", signToken(t, key, header, with("iss", "https://evil.example")), true},
+		{"missing issuer", signToken(t, key, header, with("iss", nil)), true},
+		{"wrong audience", signToken(t, key, header, with("aud", "other-app")), true},
+		{"audience list without ours", signToken(t, key, header, with("aud", []string{"a", "b"})), true},
+		{"expired", signToken(t, key, header, with("exp", testNow.Add(-2*time.Minute).Unix())), true},
+		{"no expiry", signToken(t, key, header, with("exp", nil)), true},
+		{"not valid yet", signToken(t, key, header, with("nbf", testNow.Add(5*time.Minute).Unix())), true},
+		{"no subject", signToken(t, key, header, with("sub", nil)), true},
+		{"not a JWT", "not-a-token", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), test.token)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.UID != "user-1" || claims.Email != "user@example.com" || claims.Audience != "facial-scan" {
+				t.Errorf("Verify() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestNewJWKSVerifierNeedsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(`{"keys": [{"kty": "EC", "kid": "ec"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJWKSVerifier(path, "", ""); err == nil {
+		t.Error("NewJWKSVerifier() accepted a JWKS without RSA keys")
+	}
+	if _, err := NewJWKSVerifier("", "", ""); err == nil {
+		t.Error("NewJWKSVerifier() accepted an empty path")
+	}
+}
+
+func TestAuthRequiredWithStaticVerifier(t *testing.T) {
+	previous := Verifier
+	defer func() { Verifier = previous }()
+	Verifier = NewStaticVerifier(map[string]*Claims{
+		"good-token":   {UID: "user-1", Values: map[string]interface{}{"roles": []interface{}{"admin"}}},
+		"no-uid-token": {},
+	})
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Get("/", AuthRequired(), func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("user_id").(string))
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"valid token", "Bearer good-token", fiber.StatusOK},
+		{"unknown token", "Bearer other-token", fiber.StatusUnauthorized},
+		{"token without uid", "Bearer no-uid-token", fiber.StatusUnauthorized},
+		{"no header", "", fiber.StatusUnauthorized},
+		{"not bearer", "Basic good-token", fiber.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if test.wantStatus == fiber.StatusOK && resp.Header.Get("X-User-ID") != "user-1" {
+				t.Errorf("X-User-ID = %q, want user-1", resp.Header.Get("X-User-ID"))
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package models
+
+// ImageUploadMessage is the value of an image-upload Kafka message. The image
+// itself is not in the message: the gateway stores it in Cloud Storage first
+// and the message points at it, with the size and SHA-256 checksum the
+// image-upload-service checks before using it. The image-upload-service keeps
+// a copy of this type.
+type ImageUploadMessage struct {
+	JobId       string `json:"job_id"`
+	UserId      string `json:"user_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Bucket      string `json:"bucket"`
+	Object      string `json:"object"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// ImageStatus is where an image is in its lifecycle, as recorded by the
+// image-upload-service.
+type ImageStatus string
+
+const (
+	ImageUploaded   ImageStatus = "uploaded"
+	ImageNormalized ImageStatus = "normalized"
+	ImageScoring    ImageStatus = "scoring"
+	ImageScored     ImageStatus = "scored"
+	ImageRejected   ImageStatus = "rejected"
+	ImageFailed     ImageStatus = "failed"
+)
+
+// Done reports whether an image has finished processing, one way or another.
+func (s ImageStatus) Done() bool {
+	return s == ImageScored || s == ImageRejected || s == ImageFailed
+}
+
+// Image is a user's uploaded image, as served by /api/images. Its document
+// is created when the upload is picked up and shares the scan job's ID; the
+// scores are set once it is scored. Timestamps holds when it reached each
+// status, and Error and ErrorCode why a rejected or failed image was not
+// scored. Like jobs' images, ImageURL and the renditions' URLs are signed
+// each time the image is read.
+type Image struct {
+	ID         string               `json:"id" firestore:"-"`
+	UserId     string               `json:"user_id"`
+	JobId      string               `json:"job_id,omitempty"`
+	Filename   string               `json:"filename,omitempty"`
+	Status     ImageStatus          `json:"status"`
+	Timestamps map[string]int64     `json:"timestamps,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	ErrorCode  string               `json:"error_code,omitempty"`
+	ImageKey   string               `json:"-"`
+	ImageURL   string               `json:"image_url,omitempty" firestore:"-"`
+	Renditions map[string]Rendition `json:"renditions,omitempty"`
+	Quality    *Quality             `json:"quality,omitempty"`
+	// Review is pending while a flagged image waits for a moderator.
+	Review                string  `json:"review,omitempty"`
+	ContentHash           string  `json:"-"`
+	TotalScore            float32 `json:"total_score"`
+	Symmetry              float64 `json:"symmetry"`
+	FacialDefinition      float64 `json:"facial_definition"`
+	Jawline               float64 `json:"jawline"`
+	Cheekbones            float64 `json:"cheekbones"`
+	JawlineToCheekbones   float64 `json:"jawline_to_cheekbones"`
+	CanthalTilt           float64 `json:"canthal_tilt"`
+	ProportionAndRatios   float64 `json:"proportion_and_ratios"`
+	SkinQuality           float64 `json:"skin_quality"`
+	LipFullness           float64 `json:"lip_fullness"`
+	FacialFat             float64 `json:"facial_fat"`
+	CompleteFacialHarmony float64 `json:"complete_facial_harmony"`
+	CreatedAt             int64   `json:"created_at"`
+	UpdatedAt             int64   `json:"updated_at"`
+}
@@ -0,0 +1,75 @@
+package models
+
+type JobStatus string
+
+// A scan job moves from queued to stored once the image-upload-service has
+// saved the image, to scoring once it has been handed to the image processing
+// service, and ends as scored or failed.
+const (
+	JobQueued  JobStatus = "queued"
+	JobStored  JobStatus = "stored"
+	JobScoring JobStatus = "scoring"
+	JobScored  JobStatus = "scored"
+	JobFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID       string    `json:"id"`
+	UserId   string    `json:"user_id"`
+	Status   JobStatus `json:"status"`
+	Filename string    `json:"filename"`
+	// ImageKey is the private object the image is stored as. ImageURL is a
+	// signed URL for it, made each time the job is read.
+	ImageKey string `json:"-"`
+	ImageURL string `json:"image_url,omitempty" firestore:"-"`
+	// Renditions are resized copies of the image, by name.
+	Renditions map[string]Rendition `json:"renditions,omitempty"`
+	// ImageDeleted is set once the user has deleted the job's image, which
+	// takes the image, its renditions and the result off the job.
+	ImageDeleted bool `json:"image_deleted,omitempty"`
+	// Duplicate is set when the job reused the score of an identical image
+	// the user uploaded before.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// UnderReview is set when the image looks like another user's image or
+	// a blocklisted one. It is scored, but kept off the leaderboards until a
+	// moderator has reviewed it.
+	UnderReview bool                   `json:"under_review,omitempty"`
+	ImageId     string                 `json:"image_id,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	// ErrorCode says why a failed job's image was turned down, such as
+	// no_face, multiple_faces, too_dark or blurry.
+	ErrorCode string `json:"error_code,omitempty"`
+	// Quality is what the quality check measured and the retake advice it
+	// gave, both for rejected images and, as warnings, for scored ones.
+	Quality   *Quality `json:"quality,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// Rendition is a resized copy of a job's image. Like the image, its URL is
+// signed each time the job is read.
+type Rendition struct {
+	Key    string `json:"-"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url,omitempty" firestore:"-"`
+}
+
+// Quality is the quality check's result for a job's image.
+type Quality struct {
+	Sharpness  float64        `json:"sharpness"`
+	Brightness float64        `json:"brightness"`
+	Contrast   float64        `json:"contrast"`
+	Resolution int            `json:"resolution"`
+	FaceSize   int            `json:"face_size,omitempty"`
+	Issues     []QualityIssue `json:"issues"`
+}
+
+// QualityIssue is a reason to retake a photo. Severity is reject when it kept
+// the image from being scored and warn otherwise.
+type QualityIssue struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
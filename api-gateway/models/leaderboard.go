@@ -0,0 +1,33 @@
+package models
+
+// LeaderboardResponse is the body of GET /api/leaderboard. Entries are ordered
+// by high score, best first. NextCursor is set when there are more entries; pass
+// it back as the cursor query parameter to get the next page.
+type LeaderboardResponse struct {
+	Leaderboard []LeaderboardEntry `json:"leaderboard"`
+	NextCursor  string             `json:"next_cursor,omitempty"`
+}
+
+// LeaderboardEntry is a user and their best scored image, as produced by the
+// leaderboard-service.
+type LeaderboardEntry struct {
+	Username      string         `json:"username"`
+	ImageResponse ImageDataStore `json:"image_response"`
+}
+
+type ImageDataStore struct {
+	ImageURL              string  `json:"image_url"`
+	UserId                string  `json:"user_id"`
+	TotalScore            float32 `json:"total_score"`
+	Symmetry              float64 `json:"symmetry"`
+	FacialDefinition      float64 `json:"facial_definition"`
+	Jawline               float64 `json:"jawline"`
+	Cheekbones            float64 `json:"cheekbones"`
+	JawlineToCheekbones   float64 `json:"jawline_to_cheekbones"`
+	CanthalTilt           float64 `json:"canthal_tilt"`
+	ProportionAndRatios   float64 `json:"proportion_and_ratios"`
+	SkinQuality           float64 `json:"skin_quality"`
+	LipFullness           float64 `json:"lip_fullness"`
+	FacialFat             float64 `json:"facial_fat"`
+	CompleteFacialHarmony float64 `json:"complete_facial_harmony"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// QuotaResponse is the body of GET /api/quota: the caller's plan and, for each
+// window, how many scans it allows and how many are left. Windows are counted
+// in UTC; each resets at ResetsAt, a Unix time.
+type QuotaResponse struct {
+	Plan    string      `json:"plan"`
+	Daily   QuotaWindow `json:"daily"`
+	Weekly  QuotaWindow `json:"weekly"`
+	Monthly QuotaWindow `json:"monthly"`
+}
+
+// QuotaWindow is the use of one quota window. Limit and Remaining are null
+// when the plan does not limit the window.
+type QuotaWindow struct {
+	Limit     *int  `json:"limit"`
+	Used      int   `json:"used"`
+	Remaining *int  `json:"remaining"`
+	ResetsAt  int64 `json:"resets_at"`
+}
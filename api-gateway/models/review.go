@@ -0,0 +1,43 @@
+package models
+
+// Review states of a flagged image.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// FlaggedImage is a scored image the image-upload-service found to look like
+// another user's image or a blocklisted one. It stays off the leaderboards
+// while its review is pending.
+type FlaggedImage struct {
+	ID         string      `json:"id" firestore:"-"`
+	UserId     string      `json:"user_id"`
+	ImageKey   string      `json:"-"`
+	ImageURL   string      `json:"image_url,omitempty" firestore:"-"`
+	TotalScore float64     `json:"total_score"`
+	Flags      []FlagMatch `json:"flags"`
+	Review     string      `json:"review"`
+	ReviewedBy string      `json:"reviewed_by,omitempty"`
+	ReviewedAt int64       `json:"reviewed_at,omitempty"`
+	// Status is the image's lifecycle status; images scored before it was
+	// recorded have none.
+	Status ImageStatus `json:"-"`
+}
+
+// FlagMatch is an image a flagged image looks like: another user's image
+// (near_duplicate) or an entry of the image blocklist (blocklisted).
+// Distance is how many bits their perceptual hashes differ in.
+type FlagMatch struct {
+	Kind        string `json:"kind"`
+	ImageId     string `json:"image_id,omitempty"`
+	UserId      string `json:"user_id,omitempty"`
+	BlocklistId string `json:"blocklist_id,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Distance    int    `json:"distance"`
+}
+
+// ImageReview is the payload of PUT /api/admin/images/:id/review.
+type ImageReview struct {
+	Decision string `json:"decision" validate:"required,oneof=approved rejected"`
+}
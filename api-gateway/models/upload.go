@@ -0,0 +1,28 @@
+package models
+
+// DirectUpload is an image a client uploads straight to Cloud Storage through
+// a signed URL. It is kept in the uploads collection until the client reports
+// the upload complete and a scan job is started for it.
+type DirectUpload struct {
+	ID          string `json:"id"`
+	UserId      string `json:"user_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// SHA256 is the hex checksum the client declared for the image.
+	SHA256 string `json:"sha256"`
+	Bucket string `json:"bucket"`
+	Object string `json:"object"`
+	// JobId is set once the upload is complete.
+	JobId     string `json:"job_id,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// DirectUploadRequest is the payload of POST /api/uploads.
+type DirectUploadRequest struct {
+	Filename    string `json:"filename" validate:"required,max=255"`
+	ContentType string `json:"content_type" validate:"required,max=100"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+	SHA256      string `json:"sha256" validate:"required,min=64,max=64"`
+}
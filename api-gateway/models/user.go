@@ -9,4 +9,49 @@ type User struct {
 	Gender		string 	`json:"gender"`
 	HighScore	float64	`json:"high_score"`
 	CreatedAt	int64	`json:"created_at"`
-}
\ No newline at end of file
+	Plan		string	`json:"plan"`
+}
+
+// Registration is the payload of POST /api/register, checked by the gateway
+// and the auth-service. The uid is the caller's and is filled in by the
+// gateway.
+type Registration struct {
+	UID       string  `json:"uid" validate:"readonly,required"`
+	Email     string  `json:"email" validate:"required,email,max=254"`
+	Username  string  `json:"username" validate:"min=3,max=30,username"`
+	Password  string  `json:"password" validate:"min=8,max=128"`
+	Age       int     `json:"age" validate:"min=13,max=120"`
+	Gender    string  `json:"gender" validate:"oneof=Male Female"`
+	HighScore float64 `json:"high_score" validate:"readonly"`
+	CreatedAt int64   `json:"created_at" validate:"readonly"`
+	Plan      string  `json:"plan" validate:"readonly"`
+}
+
+// ProfileUpdate is the payload of POST /api/create-account, checked by the
+// gateway and the user-management-service.
+type ProfileUpdate struct {
+	UID       string  `json:"uid" validate:"readonly,required"`
+	Email     string  `json:"email" validate:"email,max=254"`
+	Username  string  `json:"username" validate:"required,min=3,max=30,username"`
+	Age       int     `json:"age" validate:"required,min=13,max=120"`
+	Gender    string  `json:"gender" validate:"required,oneof=Male Female"`
+	HighScore float64 `json:"high_score" validate:"readonly"`
+	CreatedAt int64   `json:"created_at" validate:"readonly"`
+	Plan      string  `json:"plan" validate:"readonly"`
+}
+
+// RoleAssignment is the payload of PUT /api/admin/roles, checked by the
+// gateway and the auth-service.
+type RoleAssignment struct {
+	UID        string   `json:"uid" validate:"required"`
+	Roles      []string `json:"roles" validate:"oneof=admin moderator"`
+	AssignedBy string   `json:"assigned_by" validate:"readonly,required"`
+}
+
+// PlanAssignment is the payload of PUT /api/admin/plans, checked by the
+// gateway and the user-management-service.
+type PlanAssignment struct {
+	UID        string `json:"uid" validate:"required"`
+	Plan       string `json:"plan" validate:"required,oneof=free plus pro"`
+	AssignedBy string `json:"assigned_by" validate:"readonly,required"`
+}
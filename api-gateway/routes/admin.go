@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"api-gateway/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupAdminRoutes adds the operational endpoints under /api/admin. The group
+// is open to users whose roles grant middleware.PermissionAdminAccess; routes
+// that need more ask for their own permission.
+func setupAdminRoutes(api fiber.Router, auth fiber.Handler) {
+	admin := api.Group("/admin", auth, middleware.RequirePermission(middleware.PermissionAdminAccess))
+
+	// GET /api/admin/me shows the caller's roles and what they allow.
+	admin.Get("/me", func(c *fiber.Ctx) error {
+		claims := c.Locals("claims").(*middleware.Claims)
+		permissions := []string{}
+		seen := make(map[string]bool)
+		for _, role := range claims.Roles() {
+			for _, permission := range middleware.RolePermissions[role] {
+				if !seen[permission] {
+					seen[permission] = true
+					permissions = append(permissions, permission)
+				}
+			}
+		}
+		return c.JSON(fiber.Map{
+			"uid":         claims.UID,
+			"roles":       claims.Roles(),
+			"permissions": permissions,
+		})
+	})
+
+	setupReviewRoutes(admin)
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"api-gateway/middleware"
+	"api-gateway/models"
+)
+
+// userFields is the payload of the user registration and profile messages.
+// high_score, created_at and plan are set by the services; they are listed so
+// that the models can reject them.
+var userFields = []Field{
+	{Name: "email", Type: String},
+	{Name: "username", Type: String},
+	{Name: "password", Type: String},
+	{Name: "age", Type: Int},
+	{Name: "gender", Type: String, Enum: []string{"Male", "Female"}},
+	{Name: "high_score", Type: Number},
+	{Name: "created_at", Type: Int},
+	{Name: "plan", Type: String},
+}
+
+// Definitions are the gateway operations answered over Kafka. Adding a backend
+// operation only needs an entry here.
+var Definitions = []RouteDefinition{
+	{
+		Method:       http.MethodPost,
+		Path:         "/register",
+		Auth:         true,
+		RequestTopic: "user-registration",
+		ReplyTopic:   "user-registration-response",
+		KeyField:     "email",
+		Timeout:      2 * time.Second,
+		UserField:    "uid",
+		Idempotent:   true,
+		RateLimits: []middleware.Limit{
+			{By: middleware.ByIP, Requests: 10, Per: time.Minute},
+		},
+		Schema: userFields,
+		Model:  func() interface{} { return &models.Registration{} },
+	},
+	{
+		Method:       http.MethodPost,
+		Path:         "/create-account",
+		Auth:         true,
+		RequestTopic: "user-profile-update",
+		ReplyTopic:   "user-profile-update-response",
+		KeyField:     "email",
+		Timeout:      5 * time.Second,
+		UserField:    "uid",
+		Idempotent:   true,
+		RateLimits: []middleware.Limit{
+			{By: middleware.ByUser, Requests: 10, Per: time.Minute},
+		},
+		Schema: userFields,
+		Model:  func() interface{} { return &models.ProfileUpdate{} },
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/has-username",
+		Auth:         true,
+		RequestTopic: "username-check",
+		ReplyTopic:   "username-check-response",
+		KeyField:     "uid",
+		Timeout:      5 * time.Second,
+		UserField:    "uid",
+		RateLimits: []middleware.Limit{
+			{By: middleware.ByUser, Requests: 60, Per: time.Minute},
+		},
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/leaderboard",
+		Auth:         true,
+		RequestTopic: "leaderboard-{gender}",
+		ReplyTopic:   "leaderboard-{gender}-response",
+		KeyField:     "gender",
+		Timeout:      5 * time.Second,
+		RateLimits: []middleware.Limit{
+			{By: middleware.ByUser, Requests: 120, Per: time.Minute, Burst: 20},
+			{By: middleware.ByIP, Requests: 600, Per: time.Minute, Burst: 100},
+		},
+		Schema: []Field{
+			{Name: "gender", Type: String, Source: Query, Required: true, Enum: []string{"male", "female"}},
+			{Name: "limit", Type: Int, Source: Query, Default: 50, Min: bound(1), Max: bound(100)},
+			{Name: "cursor", Type: String, Source: Query},
+		},
+		Response: func() interface{} { return &models.LeaderboardResponse{} },
+	},
+	{
+		Method:       http.MethodGet,
+		Path:         "/quota",
+		Auth:         true,
+		RequestTopic: "quota-check",
+		ReplyTopic:   "quota-check-response",
+		KeyField:     "uid",
+		Timeout:      5 * time.Second,
+		UserField:    "uid",
+		RateLimits: []middleware.Limit{
+			{By: middleware.ByUser, Requests: 60, Per: time.Minute},
+		},
+		Response: func() interface{} { return &models.QuotaResponse{} },
+	},
+	{
+		Method:       http.MethodPut,
+		Path:         "/admin/roles",
+		Auth:         true,
+		Permission:   middleware.PermissionAssignRoles,
+		RequestTopic: "user-role-assign",
+		ReplyTopic:   "user-role-assign-response",
+		KeyField:     "uid",
+		Timeout:      5 * time.Second,
+		UserField:    "assigned_by",
+		Schema: []Field{
+			{Name: "uid", Type: String, Required: true},
+			{Name: "roles", Type: Strings, Required: true, Enum: []string{middleware.RoleAdmin, middleware.RoleModerator}},
+		},
+		Model: func() interface{} { return &models.RoleAssignment{} },
+	},
+	{
+		Method:       http.MethodPut,
+		Path:         "/admin/plans",
+		Auth:         true,
+		Permission:   middleware.PermissionManagePlans,
+		RequestTopic: "user-plan-assign",
+		ReplyTopic:   "user-plan-assign-response",
+		KeyField:     "uid",
+		Timeout:      5 * time.Second,
+		UserField:    "assigned_by",
+		Schema: []Field{
+			{Name: "uid", Type: String, Required: true},
+			{Name: "plan", Type: String, Required: true, Enum: []string{"free", "plus", "pro"}},
+		},
+		Model: func() interface{} { return &models.PlanAssignment{} },
+	},
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"api-gateway/utils"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	writeTimeout      = 10 * time.Second
+)
+
+// setupEventRoutes adds the streaming endpoints that push a user's events
+// (scores, high score updates and scan job progress) as they happen. Both take
+// the ID of the last event the client saw, from the Last-Event-ID header or the
+// last_event_id query parameter, and replay anything newer that is still
+// buffered.
+func setupEventRoutes(api fiber.Router, auth fiber.Handler) {
+	api.Get("/events", auth, streamEvents)
+
+	api.Get("/ws", auth, func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	}, websocket.New(streamWebSocket))
+}
+
+// streamEvents serves the user's events as Server-Sent Events.
+func streamEvents(c *fiber.Ctx) error {
+	uid := c.Locals("user_id").(string)
+	lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+	sub, missed := utils.Events.Subscribe(uid, lastEventID)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer utils.Events.Unsubscribe(sub)
+
+		fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+		for _, event := range missed {
+			writeServerSentEvent(w, event)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				writeServerSentEvent(w, event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			// A failed flush means the client has gone away.
+			if err := w.Flush(); err != nil {
+				log.Printf("Event stream for user %s closed: %v", uid, err)
+				return
+			}
+		}
+	}))
+	return nil
+}
+
+func writeServerSentEvent(w *bufio.Writer, event utils.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\n", event.ID, event.Topic)
+	for _, line := range strings.Split(string(event.Data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+type webSocketEvent struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// streamWebSocket serves the user's events as JSON messages over a WebSocket,
+// with pings as heartbeats.
+func streamWebSocket(conn *websocket.Conn) {
+	uid := conn.Locals("user_id").(string)
+	sub, missed := utils.Events.Subscribe(uid, conn.Query("last_event_id"))
+	defer utils.Events.Unsubscribe(sub)
+
+	// The client does not send us anything, but reading is how we notice it
+	// has closed the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range missed {
+		if err := writeWebSocketEvent(conn, event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "resume from last event"), time.Now().Add(writeTimeout))
+				return
+			}
+			if err := writeWebSocketEvent(conn, event); err != nil {
+				log.Printf("Event socket for user %s closed: %v", uid, err)
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func writeWebSocketEvent(conn *websocket.Conn, event utils.Event) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(webSocketEvent{
+		ID:    event.ID,
+		Event: event.Topic,
+		Data:  json.RawMessage(event.Data),
+	})
+}
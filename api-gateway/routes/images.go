@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+
+	"api-gateway/apierror"
+	"api-gateway/models"
+	"api-gateway/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupImageRoutes lets users manage their images, scored or not:
+//
+//	GET    /api/images?status=scored&limit=20   lists them, newest first
+//	GET    /api/images/:id                      gets one
+//	DELETE /api/images/:id                      deletes one and its files
+//
+// Other users' images are reported as missing rather than forbidden.
+func setupImageRoutes(api fiber.Router, auth fiber.Handler) {
+	api.Get("/images", auth, listImages)
+	api.Get("/images/:id", auth, getImage)
+	api.Delete("/images/:id", auth, deleteImage)
+}
+
+func listImages(c *fiber.Ctx) error {
+	uid := c.Locals("user_id").(string)
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		return apierror.New(apierror.CodeInvalidRequest, "limit must be between 1 and 100")
+	}
+	imageStatus := models.ImageStatus(c.Query("status"))
+	switch imageStatus {
+	case "", models.ImageUploaded, models.ImageNormalized, models.ImageScoring,
+		models.ImageScored, models.ImageRejected, models.ImageFailed:
+	default:
+		return apierror.New(apierror.CodeInvalidRequest, "status must be one of uploaded, normalized, scoring, scored, rejected and failed")
+	}
+
+	images, err := utils.ListImages(uid, imageStatus, limit)
+	if err != nil {
+		log.Printf("Error listing images: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error listing images")
+	}
+	for i := range images {
+		signImage(&images[i])
+	}
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"images": images,
+	})
+}
+
+func getImage(c *fiber.Ctx) error {
+	image, err := ownImage(c)
+	if err != nil {
+		return err
+	}
+	signImage(image)
+	return c.Status(http.StatusOK).JSON(image)
+}
+
+// deleteImage removes a finished image. Images still being processed cannot
+// be deleted, as the pipeline would recreate parts of them.
+func deleteImage(c *fiber.Ctx) error {
+	image, err := ownImage(c)
+	if err != nil {
+		return err
+	}
+	if !image.Status.Done() {
+		return apierror.New(apierror.CodeConflict, "Image is still being processed")
+	}
+
+	if err := utils.DeleteImage(image); err != nil {
+		log.Printf("Error deleting image %s: %v", image.ID, err)
+		return apierror.New(apierror.CodeInternal, "Error deleting image")
+	}
+	log.Printf("User %s deleted image %s", image.UserId, image.ID)
+
+	if image.Status == models.ImageScored {
+		if err := utils.RecomputeHighScore(image.UserId); err != nil {
+			log.Printf("Error recomputing high score of user %s: %v", image.UserId, err)
+		}
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// ownImage returns the image named in the path when it belongs to the
+// caller.
+func ownImage(c *fiber.Ctx) (*models.Image, error) {
+	image, err := utils.GetImage(c.Params("id"))
+	if err != nil {
+		log.Printf("Error getting image: %v", err)
+		return nil, apierror.New(apierror.CodeInternal, "Error getting image")
+	}
+	if image == nil || image.UserId != c.Locals("user_id").(string) {
+		return nil, apierror.New(apierror.CodeNotFound, "Image not found")
+	}
+	return image, nil
+}
+
+// signImage fills in short-lived URLs for an image and its renditions. An
+// image whose URLs cannot be signed is still returned, without them.
+func signImage(image *models.Image) {
+	url, err := utils.SignedImageURL(image.ImageKey)
+	if err != nil {
+		log.Printf("Error signing image URL for image %s: %v", image.ID, err)
+		return
+	}
+	image.ImageURL = url
+	for name, rendition := range image.Renditions {
+		if rendition.URL, err = utils.SignedImageURL(rendition.Key); err != nil {
+			log.Printf("Error signing %s URL for image %s: %v", name, image.ID, err)
+		}
+		image.Renditions[name] = rendition
+	}
+}
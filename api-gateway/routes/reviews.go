@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"api-gateway/apierror"
+	"api-gateway/middleware"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupReviewRoutes lets moderators review the images the image-upload-service
+// flagged as looking like another user's image or a blocklisted one:
+//
+//	GET /api/admin/images/flagged          lists images awaiting review
+//	PUT /api/admin/images/:id/review       approves or rejects one
+//
+// Approved images return to the leaderboards. Rejected images stay off them
+// for good, and the user's high score falls back to their best other image.
+func setupReviewRoutes(admin fiber.Router) {
+	images := admin.Group("/images", middleware.RequirePermission(middleware.PermissionModerateScans))
+	images.Get("/flagged", listFlaggedImages)
+	images.Put("/:id/review", reviewImage)
+}
+
+func listFlaggedImages(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	images, err := utils.ListFlaggedImages(limit)
+	if err != nil {
+		log.Printf("Error listing flagged images: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error listing flagged images")
+	}
+	for i := range images {
+		signFlaggedImage(&images[i])
+	}
+	return c.Status(http.StatusOK).JSON(fiber.Map{"images": images})
+}
+
+func reviewImage(c *fiber.Ctx) error {
+	var review models.ImageReview
+	errs, err := validation.Decode(c.Body(), &review)
+	if err != nil {
+		return apierror.New(apierror.CodeInvalidRequest, "Invalid request body")
+	}
+	if len(errs) > 0 {
+		return apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs)
+	}
+
+	reviewer := c.Locals("user_id").(string)
+	image, err := utils.ReviewImage(c.Params("id"), review.Decision, reviewer)
+	switch {
+	case errors.Is(err, utils.ErrImageNotFlagged):
+		return apierror.New(apierror.CodeConflict, "Image is not flagged")
+	case err != nil:
+		log.Printf("Error reviewing image %s: %v", c.Params("id"), err)
+		return apierror.New(apierror.CodeInternal, "Error reviewing image")
+	case image == nil:
+		return apierror.New(apierror.CodeNotFound, "Image not found")
+	}
+	log.Printf("User %s %s image %s", reviewer, image.Review, image.ID)
+
+	// Either decision can change the user's best image that counts.
+	if err := utils.RecomputeHighScore(image.UserId); err != nil {
+		log.Printf("Error recomputing high score of user %s: %v", image.UserId, err)
+	}
+	signFlaggedImage(image)
+	return c.Status(http.StatusOK).JSON(image)
+}
+
+func signFlaggedImage(image *models.FlaggedImage) {
+	url, err := utils.SignedImageURL(image.ImageKey)
+	if err != nil {
+		log.Printf("Error signing image URL for image %s: %v", image.ID, err)
+		return
+	}
+	image.ImageURL = url
+}
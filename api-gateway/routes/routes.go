@@ -2,156 +2,206 @@ package routes
 
 import (
 	// "api-gateway/middleware"
+	"api-gateway/apierror"
 	"api-gateway/middleware"
 	"api-gateway/models"
 	"api-gateway/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 func SetupRoutes(app *fiber.App) {
-	
-	api := app.Group("/api", middleware.AuthRequired())
-
-	api.Post("/register", func(c *fiber.Ctx) error {
-		var user models.User
-		if err := c.BodyParser(&user); err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error":"Invalid request, bad user object",
-			})
+	registerDefinitions(app, Definitions)
+
+	api := app.Group("/api")
+	auth := middleware.AuthRequired()
+
+	// Every upload starts a paid scoring round, so uploads are held to a few
+	// per user and per network. Multipart, tus and direct uploads share the
+	// budget.
+	uploadLimit := middleware.RateLimit("image-uploads",
+		middleware.Limit{By: middleware.ByUser, Requests: 20, Per: time.Hour, Burst: 5},
+		middleware.Limit{By: middleware.ByIP, Requests: 60, Per: time.Hour, Burst: 10},
+	)
+
+	setupEventRoutes(api, auth)
+	setupImageRoutes(api, auth)
+	setupAdminRoutes(api, auth)
+	setupTusRoutes(api, auth, uploadLimit)
+	setupDirectUploadRoutes(api, auth, uploadLimit)
+
+	api.Post("/image-upload", auth, middleware.Idempotent(), uploadLimit, func(c *fiber.Ctx) error {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return apierror.New(apierror.CodeInvalidRequest, "No file uploaded")
 		}
+		fileHeader, err := file.Open()
+		if err != nil {
+			log.Printf("Error opening file: %v", err)
+			return apierror.New(apierror.CodeInternal, "Error opening file")
+		}
+		defer fileHeader.Close()
 
-		user.UID = c.Locals("user_id").(string)
-
-		err := utils.ProduceKafkaMessage("user-registration", user)
+		data, err := io.ReadAll(fileHeader)
 		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error producing message to Kafka",
-			})
+			log.Printf("Error reading file: %v", err)
+			return apierror.New(apierror.CodeInternal, "Error reading file")
 		}
 
-		response, statusCode, err := utils.ConsumeKafkaMessage("user-registration-response", user.Email, 2 * time.Second)
+		job, err := startScanJob(uuid.NewString(), c.Locals("user_id").(string), file.Filename, file.Header.Get("Content-Type"), data)
 		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error consuming message from Kafka",
-			})
+			return err
 		}
 
-		return c.Status(statusCode).JSON(response)
+		c.Location("/api/jobs/" + job.ID)
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{
+			"job_id": job.ID,
+			"status": job.Status,
+		})
 	})
 
-	
-	
-	api.Post("/create-account", func(c *fiber.Ctx) error {
-		log.Println("Create account")
+	api.Get("/jobs", auth, func(c *fiber.Ctx) error {
 		uid := c.Locals("user_id").(string)
-		var user models.User
-		if err := c.BodyParser(&user); err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error":"Invalid request, bad user object",
-			})
+		limit := c.QueryInt("limit", 20)
+		if limit < 1 || limit > 100 {
+			return apierror.New(apierror.CodeInvalidRequest, "limit must be between 1 and 100")
 		}
-		user.UID = uid
-		log.Println("Sending kafka message to create account")
-		err := utils.ProduceKafkaMessage("user-profile-update", user)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error producing message to Kafka",
-			})
-		}
-		log.Println("Consuming kafka message to create account")
-		response, statusCode, err := utils.ConsumeKafkaMessage("user-profile-update-response", user.Email, 5 * time.Second)
+
+		jobs, err := utils.ListJobs(uid, limit)
 		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error consuming message from Kafka",
-			})
+			log.Printf("Error listing jobs: %v", err)
+			return apierror.New(apierror.CodeInternal, "Error listing jobs")
 		}
-		log.Printf("Response: %v", response)
-		return c.Status(statusCode).JSON(response)
-	})
-
-	
-	
-	api.Get("/has-username", func(c *fiber.Ctx) error {
-		var hasUsername struct {
-			UID		string	 `json:"uid"`
+		for i := range jobs {
+			signJobImage(&jobs[i])
 		}
-		hasUsername.UID = c.Locals("user_id").(string)
-			err := utils.ProduceKafkaMessage("username-check", hasUsername)
-			if err != nil {
-				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-					"error":"Error producing message to Kafka",
-				})
-			}
-
-			response, statusCode, err := utils.ConsumeKafkaMessage("username-check-response", hasUsername.UID, 5 * time.Second)
-			if err != nil {
-				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-					"error":"Error consuming message from Kafka",
-				})
-			}
-			return c.Status(statusCode).JSON(response)
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"jobs": jobs,
 		})
+	})
 
-	
-	
-	api.Post("/image-upload", func(c *fiber.Ctx) error {
-		file, err := c.FormFile("file")
+	api.Get("/jobs/:id", auth, func(c *fiber.Ctx) error {
+		uid := c.Locals("user_id").(string)
+		job, err := utils.GetJob(c.Params("id"))
 		if err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error":"No file uploaded",
-			})
+			log.Printf("Error getting job: %v", err)
+			return apierror.New(apierror.CodeInternal, "Error getting job")
 		}
-		fileHeader, err := file.Open()
-		if err != nil {
-			log.Printf("Error opening file: %v", err)
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error opening file",
-			})
+		// Other users' jobs are reported as missing rather than forbidden.
+		if job == nil || job.UserId != uid {
+			return apierror.New(apierror.CodeNotFound, "Job not found")
 		}
-		defer fileHeader.Close()
+		signJobImage(job)
+		return c.Status(http.StatusOK).JSON(job)
+	})
+}
 
-		// Create a buffer to read the file info
-		buf := make([]byte, file.Size)
-		_, err = fileHeader.Read(buf)
-		if err != nil {
-			log.Println("Error reading file")
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error reading file",
-			})
+// signJobImage fills in short-lived URLs for the job's stored image and its
+// renditions. A job whose URLs cannot be signed is still returned, without
+// them.
+func signJobImage(job *models.Job) {
+	url, err := utils.SignedImageURL(job.ImageKey)
+	if err != nil {
+		log.Printf("Error signing image URL for job %s: %v", job.ID, err)
+		return
+	}
+	job.ImageURL = url
+	for name, rendition := range job.Renditions {
+		if rendition.URL, err = utils.SignedImageURL(rendition.Key); err != nil {
+			log.Printf("Error signing %s URL for job %s: %v", name, job.ID, err)
 		}
-		uid := c.Locals("user_id").(string)
-		kafkaMessage := &sarama.ProducerMessage{
-			Topic: "image-upload",
-			Value: sarama.ByteEncoder(buf),
-			Headers: []sarama.RecordHeader{
-				{Key: []byte("filename"), Value: []byte(file.Filename)},
-				{Key: []byte("contentType"), Value: []byte(file.Header.Get("Content-Type"))},
-				{Key: []byte("userID"), Value: []byte(uid)},
-			},
+		job.Renditions[name] = rendition
+	}
+}
+
+// startScanJob records a scan job with the given ID for an uploaded image and
+// hands the image to the image-upload pipeline. Both the multipart upload and
+// completed tus uploads go through it.
+func startScanJob(jobID, uid, filename, contentType string, data []byte) (*models.Job, error) {
+	job, err := createScanJob(jobID, uid, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim check: the image goes to storage and the message only points at
+	// it, which keeps image-upload messages small.
+	message := &models.ImageUploadMessage{
+		JobId:       job.ID,
+		UserId:      uid,
+		Filename:    filename,
+		ContentType: contentType,
+	}
+	if err := utils.StageImage(context.Background(), message, data); err != nil {
+		log.Printf("Error staging image for job %s: %v", job.ID, err)
+		if err := utils.FailJob(job.ID, "Error storing image"); err != nil {
+			log.Printf("Error failing job %s: %v", job.ID, err)
 		}
+		return nil, apierror.New(apierror.CodeUnavailable, "Error storing image")
+	}
 
-		// Produce the message to kafka
-		err = utils.ProduceKafkaMessageWithHeaders(kafkaMessage)
-		if err != nil {
-			log.Println("Error producing message to kafka")
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"error creating kafka message",
-			})
+	if err := publishScanJob(message); err != nil {
+		if err := utils.DeleteStagedImage(context.Background(), message); err != nil {
+			log.Printf("Error deleting staged image for job %s: %v", job.ID, err)
 		}
+		return nil, err
+	}
+	return job, nil
+}
 
-		// Wait for response from image processing service
-		response, statusCode, err := utils.ConsumeKafkaMessage("image-processing-response", uid, 30 * time.Second)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error":"Error consuming message from Kafka",
-			})
+// createScanJob records a queued scan job before its image is handed off, so
+// the caller can poll it as soon as we answer.
+func createScanJob(id, uid, filename string) (*models.Job, error) {
+	job := &models.Job{
+		ID:       id,
+		UserId:   uid,
+		Filename: filename,
+	}
+	if err := utils.CreateJob(job); err != nil {
+		log.Printf("Error creating job: %v", err)
+		return nil, apierror.New(apierror.CodeInternal, "Error creating job")
+	}
+	return job, nil
+}
+
+// publishScanJob sends a staged image to the image-upload-service, failing
+// its job if the message cannot be sent.
+func publishScanJob(message *models.ImageUploadMessage) error {
+	value, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	kafkaMessage := &sarama.ProducerMessage{
+		Topic: "image-upload",
+		Key:   sarama.StringEncoder(message.UserId),
+		Value: sarama.ByteEncoder(value),
+	}
+
+	// Produce the message to kafka
+	if err := utils.ProduceKafkaMessageWithHeaders(kafkaMessage); err != nil {
+		log.Println("Error producing message to kafka")
+		if err := utils.FailJob(message.JobId, "Error producing message to Kafka"); err != nil {
+			log.Printf("Error failing job %s: %v", message.JobId, err)
 		}
+		return apierror.New(apierror.CodeUnavailable, "Error sending image for scoring")
+	}
+	return nil
+}
 
-		return c.Status(statusCode).JSON(response)
-	})
+// replyError turns a failed request/reply round trip into an API error.
+func replyError(err error) error {
+	if errors.Is(err, utils.ErrReplyTimeout) {
+		log.Printf("Timed out waiting for reply: %v", err)
+		return apierror.New(apierror.CodeTimeout, "Timed out waiting for the service to answer")
+	}
+	log.Printf("Error producing message to kafka: %v", err)
+	return apierror.New(apierror.CodeUnavailable, "Error sending request to the service")
 }
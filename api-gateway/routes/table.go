@@ -0,0 +1,471 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/apierror"
+	"api-gateway/middleware"
+	"api-gateway/utils"
+	"api-gateway/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type FieldType string
+
+const (
+	String FieldType = "string"
+	Int    FieldType = "int"
+	Number FieldType = "number"
+	Bool   FieldType = "bool"
+	// Strings is a list of strings. Enum applies to each item.
+	Strings FieldType = "strings"
+)
+
+var typeNames = map[FieldType]string{
+	String:  "a string",
+	Int:     "an integer",
+	Number:  "a number",
+	Bool:    "a boolean",
+	Strings: "a list of strings",
+}
+
+type FieldSource string
+
+const (
+	Body  FieldSource = "body"
+	Query FieldSource = "query"
+)
+
+// Field describes one field of a route's request payload.
+type Field struct {
+	Name   string
+	Type   FieldType
+	Source FieldSource // Body when empty
+	// Required fields must be present; others are left out of the payload
+	// when missing unless they have a Default.
+	Required bool
+	Default  interface{}
+	// Enum restricts a string field to these values, compared case-insensitively.
+	Enum []string
+	// Min and Max bound Int and Number fields when set.
+	Min *float64
+	Max *float64
+}
+
+// RouteDefinition declares a gateway operation that is served by sending the
+// request payload to a Kafka topic and answering with the service's reply.
+type RouteDefinition struct {
+	Method string
+	// Path is relative to /api.
+	Path string
+	Auth bool
+	// Permission, when set, is required of the user on top of Auth.
+	Permission string
+	// RequestTopic and ReplyTopic may contain {field} placeholders, which are
+	// filled in from the payload. A placeholder in ReplyTopic must name an Enum
+	// field so the gateway knows every topic to subscribe to.
+	RequestTopic string
+	ReplyTopic   string
+	// KeyField names the payload field used as the Kafka message key.
+	KeyField string
+	Timeout  time.Duration
+	// UserField, when set, is filled with the authenticated user's UID. It
+	// overrides any value sent by the client.
+	UserField string
+	Schema    []Field
+	// Model, when set, returns a pointer to the payload type. Its validate
+	// rules are checked against the payload before it is sent, and any of its
+	// read-only fields in the request are rejected.
+	Model func() interface{}
+	// Idempotent routes honor the Idempotency-Key header. It needs Auth.
+	Idempotent bool
+	// RateLimits are checked after auth, each with its own bucket for this route.
+	RateLimits []middleware.Limit
+	// Response, when set, returns a pointer to the documented response type.
+	// Successful replies are re-encoded through it.
+	Response func() interface{}
+}
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+// registerDefinitions builds a Fiber route for each definition.
+func registerDefinitions(app *fiber.App, definitions []RouteDefinition) {
+	seen := make(map[string]bool)
+	for _, definition := range definitions {
+		route := definition.Method + " " + definition.Path
+		if seen[route] {
+			log.Fatalf("Route %s is defined twice", route)
+		}
+		seen[route] = true
+		validateDefinition(definition)
+
+		handlers := []fiber.Handler{}
+		if definition.Auth {
+			handlers = append(handlers, middleware.AuthRequired())
+		}
+		if definition.Permission != "" {
+			handlers = append(handlers, middleware.RequirePermission(definition.Permission))
+		}
+		if definition.Idempotent {
+			handlers = append(handlers, middleware.Idempotent())
+		}
+		if len(definition.RateLimits) > 0 {
+			handlers = append(handlers, middleware.RateLimit(route, definition.RateLimits...))
+		}
+		handlers = append(handlers, kafkaHandler(definition))
+		app.Add(definition.Method, "/api"+definition.Path, handlers...)
+	}
+}
+
+// validateDefinition stops startup on definitions that could never work.
+func validateDefinition(definition RouteDefinition) {
+	route := definition.Method + " " + definition.Path
+	if definition.RequestTopic == "" || definition.ReplyTopic == "" {
+		log.Fatalf("Route %s needs a request and a reply topic", route)
+	}
+	if definition.Timeout <= 0 {
+		log.Fatalf("Route %s needs a timeout", route)
+	}
+	if definition.UserField != "" && !definition.Auth {
+		log.Fatalf("Route %s stamps the user but does not require auth", route)
+	}
+	if definition.Permission != "" && !definition.Auth {
+		log.Fatalf("Route %s needs a permission but does not require auth", route)
+	}
+	if definition.Idempotent && !definition.Auth {
+		log.Fatalf("Route %s is idempotent but does not require auth", route)
+	}
+	if definition.Model != nil {
+		if model := reflect.TypeOf(definition.Model()); model.Kind() != reflect.Pointer || model.Elem().Kind() != reflect.Struct {
+			log.Fatalf("Route %s model must be a pointer to a struct", route)
+		}
+	}
+	for _, limit := range definition.RateLimits {
+		if limit.Requests <= 0 || limit.Per <= 0 {
+			log.Fatalf("Route %s has a rate limit without requests or period", route)
+		}
+	}
+	if definition.KeyField != "" && definition.KeyField != definition.UserField && findField(definition.Schema, definition.KeyField) == nil {
+		log.Fatalf("Route %s uses unknown key field %s", route, definition.KeyField)
+	}
+	for _, name := range placeholders(definition.RequestTopic) {
+		field := findField(definition.Schema, name)
+		if field == nil || (!field.Required && field.Default == nil) {
+			log.Fatalf("Route %s request topic needs required field %s", route, name)
+		}
+	}
+	for _, name := range placeholders(definition.ReplyTopic) {
+		if field := findField(definition.Schema, name); field == nil || len(field.Enum) == 0 {
+			log.Fatalf("Route %s reply topic needs enum field %s", route, name)
+		}
+	}
+}
+
+// ReplyTopics returns every reply topic the definitions can be answered on.
+func ReplyTopics(definitions []RouteDefinition) []string {
+	seen := make(map[string]bool)
+	topics := []string{}
+	for _, definition := range definitions {
+		expanded := []string{definition.ReplyTopic}
+		for _, name := range placeholders(definition.ReplyTopic) {
+			field := findField(definition.Schema, name)
+			if field == nil {
+				continue
+			}
+			var next []string
+			for _, topic := range expanded {
+				for _, value := range field.Enum {
+					next = append(next, strings.ReplaceAll(topic, "{"+name+"}", value))
+				}
+			}
+			expanded = next
+		}
+		for _, topic := range expanded {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+func kafkaHandler(definition RouteDefinition) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload, errs, err := buildPayload(c, definition)
+		if err != nil {
+			return apierror.New(apierror.CodeInvalidRequest, "Invalid request, "+err.Error())
+		}
+		if len(errs) > 0 {
+			return apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs)
+		}
+
+		topic := renderTopic(definition.RequestTopic, payload)
+		key := ""
+		if definition.KeyField != "" {
+			if value, exists := payload[definition.KeyField]; exists {
+				key = fmt.Sprint(value)
+			}
+		}
+
+		response, statusCode, err := utils.RequestReply(topic, key, payload, definition.Timeout)
+		if err != nil {
+			return replyError(err)
+		}
+		if replyErr := replyFailure(*response, statusCode); replyErr != nil {
+			return replyErr
+		}
+		if definition.Response == nil {
+			return c.Status(statusCode).JSON(response)
+		}
+
+		// Re-encode through the documented shape so nothing else leaks out.
+		shaped := definition.Response()
+		data, err := json.Marshal(response)
+		if err == nil {
+			err = json.Unmarshal(data, shaped)
+		}
+		if err != nil {
+			log.Printf("Error decoding reply from %s: %v", topic, err)
+			return apierror.New(apierror.CodeInternal, "Error decoding reply")
+		}
+		return c.Status(statusCode).JSON(shaped)
+	}
+}
+
+// replyFailure returns the error a service reported in its reply, if any.
+// Replies carry an apierror.Error in "error"; replies that only have a failing
+// statusCode are mapped from it.
+func replyFailure(response fiber.Map, statusCode int) *apierror.Error {
+	if raw, ok := response["error"].(map[string]interface{}); ok {
+		replyErr := &apierror.Error{}
+		data, err := json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(data, replyErr)
+		}
+		if err == nil && replyErr.Code != "" {
+			return replyErr
+		}
+	}
+	if statusCode < http.StatusBadRequest {
+		return nil
+	}
+	message, _ := response["message"].(string)
+	if message == "" {
+		message, _ = response["error"].(string)
+	}
+	return apierror.FromStatus(statusCode, message)
+}
+
+// buildPayload collects the schema's fields from the request, checking their
+// types and constraints, and then checks the definition's Model rules. Fields
+// that are not in the schema are dropped. A body that is not JSON is an error;
+// fields that break the rules are returned as validation errors.
+func buildPayload(c *fiber.Ctx, definition RouteDefinition) (map[string]interface{}, validation.Errors, error) {
+	body := map[string]interface{}{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return nil, nil, fmt.Errorf("body is not a JSON object")
+		}
+	}
+
+	var errs validation.Errors
+	payload := make(map[string]interface{}, len(definition.Schema)+1)
+	for _, field := range definition.Schema {
+		value, present, fieldError := fieldValue(c, body, field)
+		if fieldError != nil {
+			errs = append(errs, *fieldError)
+			continue
+		}
+		if !present {
+			if field.Required {
+				errs.Add(field.Name, validation.CodeRequired, "%s is required", field.Name)
+			}
+			if field.Default == nil {
+				continue
+			}
+			value = field.Default
+		}
+		payload[field.Name] = value
+	}
+
+	// The model sees only the fields that passed the schema, and reports one
+	// error per field at most.
+	if definition.Model != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		modelErrs, err := validation.Decode(data, definition.Model())
+		if err != nil {
+			return nil, nil, err
+		}
+		reported := make(map[string]bool, len(errs))
+		for _, fieldError := range errs {
+			reported[fieldError.Field] = true
+		}
+		for _, fieldError := range modelErrs {
+			if !reported[fieldError.Field] {
+				reported[fieldError.Field] = true
+				errs = append(errs, fieldError)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	if definition.UserField != "" {
+		payload[definition.UserField] = c.Locals("user_id").(string)
+	}
+	return payload, nil, nil
+}
+
+func fieldValue(c *fiber.Ctx, body map[string]interface{}, field Field) (interface{}, bool, *validation.FieldError) {
+	var value interface{}
+	if field.Source == Query {
+		raw := c.Query(field.Name)
+		if raw == "" {
+			return nil, false, nil
+		}
+		parsed, err := parseQueryValue(raw, field.Type)
+		if err != nil {
+			return nil, true, typeError(field)
+		}
+		value = parsed
+	} else {
+		raw, exists := body[field.Name]
+		if !exists || raw == nil {
+			return nil, false, nil
+		}
+		value = raw
+	}
+
+	switch field.Type {
+	case String:
+		text, ok := value.(string)
+		if !ok {
+			return nil, true, typeError(field)
+		}
+		return matchEnum(field, text)
+	case Int, Number:
+		number, ok := value.(float64)
+		if !ok || (field.Type == Int && number != math.Trunc(number)) {
+			return nil, true, typeError(field)
+		}
+		if field.Min != nil && number < *field.Min {
+			return nil, true, &validation.FieldError{Field: field.Name, Code: validation.CodeTooSmall, Message: fmt.Sprintf("%s must be at least %v", field.Name, *field.Min)}
+		}
+		if field.Max != nil && number > *field.Max {
+			return nil, true, &validation.FieldError{Field: field.Name, Code: validation.CodeTooLarge, Message: fmt.Sprintf("%s must be at most %v", field.Name, *field.Max)}
+		}
+		if field.Type == Int {
+			return int64(number), true, nil
+		}
+		return number, true, nil
+	case Bool:
+		flag, ok := value.(bool)
+		if !ok {
+			return nil, true, typeError(field)
+		}
+		return flag, true, nil
+	case Strings:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, true, typeError(field)
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			text, ok := item.(string)
+			if !ok {
+				return nil, true, typeError(field)
+			}
+			matched, _, fieldError := matchEnum(field, text)
+			if fieldError != nil {
+				return nil, true, fieldError
+			}
+			list = append(list, matched.(string))
+		}
+		return list, true, nil
+	}
+	return nil, true, &validation.FieldError{Field: field.Name, Code: validation.CodeInvalidType, Message: fmt.Sprintf("%s has unknown type %s", field.Name, field.Type)}
+}
+
+func typeError(field Field) *validation.FieldError {
+	return &validation.FieldError{
+		Field:   field.Name,
+		Code:    validation.CodeInvalidType,
+		Message: fmt.Sprintf("%s must be %s", field.Name, typeNames[field.Type]),
+	}
+}
+
+// matchEnum returns text, or the Enum value it matches when the field has one.
+func matchEnum(field Field, text string) (interface{}, bool, *validation.FieldError) {
+	if len(field.Enum) == 0 {
+		return text, true, nil
+	}
+	for _, allowed := range field.Enum {
+		if strings.EqualFold(text, allowed) {
+			return allowed, true, nil
+		}
+	}
+	return nil, true, &validation.FieldError{
+		Field:   field.Name,
+		Code:    validation.CodeNotAllowed,
+		Message: fmt.Sprintf("%s must be one of %s", field.Name, strings.Join(field.Enum, ", ")),
+	}
+}
+
+func parseQueryValue(raw string, fieldType FieldType) (interface{}, error) {
+	switch fieldType {
+	case Int, Number:
+		return strconv.ParseFloat(raw, 64)
+	case Bool:
+		return strconv.ParseBool(raw)
+	}
+	return raw, nil
+}
+
+func findField(schema []Field, name string) *Field {
+	for i := range schema {
+		if schema[i].Name == name {
+			return &schema[i]
+		}
+	}
+	return nil
+}
+
+// placeholders returns the names of the {field} placeholders in topic.
+func placeholders(topic string) []string {
+	var names []string
+	for {
+		start := strings.Index(topic, "{")
+		if start < 0 {
+			return names
+		}
+		end := strings.Index(topic[start:], "}")
+		if end < 0 {
+			return names
+		}
+		names = append(names, topic[start+1:start+end])
+		topic = topic[start+end+1:]
+	}
+}
+
+func renderTopic(topic string, payload map[string]interface{}) string {
+	for _, name := range placeholders(topic) {
+		topic = strings.ReplaceAll(topic, "{"+name+"}", fmt.Sprint(payload[name]))
+	}
+	return topic
+}
@@ -0,0 +1,270 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/apierror"
+	"api-gateway/uploads"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	// tusChunkType is the content type of a PATCH body.
+	tusChunkType = "application/offset+octet-stream"
+)
+
+// setupTusRoutes adds resumable uploads that follow the tus protocol
+// (https://tus.io/protocols/resumable-upload), with the creation, expiration
+// and termination extensions:
+//
+//	OPTIONS /api/uploads/tus      describes the server
+//	POST    /api/uploads/tus      creates an upload of Upload-Length bytes
+//	HEAD    /api/uploads/tus/:id  reports Upload-Offset to resume from
+//	PATCH   /api/uploads/tus/:id  appends a chunk at Upload-Offset
+//	DELETE  /api/uploads/tus/:id  abandons an upload
+//
+// Chunks are staged in uploads.Staging. When the last chunk arrives the image
+// enters the same pipeline as POST /api/image-upload, and the scan job's ID is
+// returned in the X-Job-ID header of that PATCH and of later HEADs. Uploads
+// that are not finished within uploads.TTL are removed.
+func setupTusRoutes(api fiber.Router, auth fiber.Handler, uploadLimit fiber.Handler) {
+	tus := api.Group("/uploads/tus", tusHeaders)
+
+	tus.Options("", func(c *fiber.Ctx) error {
+		c.Set("Tus-Version", tusVersion)
+		c.Set("Tus-Extension", tusExtensions)
+		c.Set("Tus-Max-Size", strconv.FormatInt(uploads.MaxSize, 10))
+		// Not part of tus 1.0.0, but clients that read it can size their
+		// chunks to fit the gateway's body limit. A whole upload fits.
+		c.Set("Tus-Max-Chunk-Size", strconv.FormatInt(uploads.MaxSize, 10))
+		return c.SendStatus(http.StatusNoContent)
+	})
+
+	tus.Post("", auth, uploadLimit, createTusUpload)
+	tus.Head("/:id", auth, headTusUpload)
+	tus.Patch("/:id", auth, patchTusUpload)
+	tus.Delete("/:id", auth, deleteTusUpload)
+}
+
+// tusHeaders answers every tus response with Tus-Resumable and turns away
+// clients that speak another version of the protocol with the 412 the
+// protocol asks for.
+func tusHeaders(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Method() != http.MethodOptions && c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return c.SendStatus(http.StatusPreconditionFailed)
+	}
+	return c.Next()
+}
+
+func createTusUpload(c *fiber.Ctx) error {
+	if c.Get("Upload-Defer-Length") != "" {
+		return apierror.New(apierror.CodeInvalidRequest, "Upload-Defer-Length is not supported")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return apierror.New(apierror.CodeInvalidRequest, "Upload-Length must be a positive number of bytes")
+	}
+	if length > uploads.MaxSize {
+		return apierror.New(apierror.CodePayloadTooLarge, "Upload is larger than "+strconv.FormatInt(uploads.MaxSize, 10)+" bytes")
+	}
+
+	metadata, err := parseTusMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return apierror.New(apierror.CodeInvalidRequest, "Upload-Metadata is not valid")
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["contentType"]
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return apierror.New(apierror.CodeInvalidRequest, "Upload-Metadata must give an image filetype")
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = "upload"
+	}
+
+	now := time.Now()
+	upload := &uploads.Upload{
+		ID:          uuid.NewString(),
+		UserId:      c.Locals("user_id").(string),
+		Length:      length,
+		Metadata:    c.Get("Upload-Metadata"),
+		Filename:    filename,
+		ContentType: contentType,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(uploads.TTL),
+	}
+	if err := uploads.Staging.Create(c.UserContext(), upload); err != nil {
+		log.Printf("Error creating upload: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error creating upload")
+	}
+
+	c.Location("/api/uploads/tus/" + upload.ID)
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Set("Tus-Max-Chunk-Size", strconv.FormatInt(uploads.MaxSize, 10))
+	return c.SendStatus(http.StatusCreated)
+}
+
+func headTusUpload(c *fiber.Ctx) error {
+	upload, err := ownedUpload(c)
+	if err != nil {
+		return err
+	}
+	c.Set("Cache-Control", "no-store")
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		c.Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.JobId != "" {
+		c.Set("X-Job-ID", upload.JobId)
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+func patchTusUpload(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderContentType) != tusChunkType {
+		return apierror.New(apierror.CodeInvalidRequest, "Content-Type must be "+tusChunkType)
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return apierror.New(apierror.CodeInvalidRequest, "Upload-Offset must be a number of bytes")
+	}
+	if _, err := ownedUpload(c); err != nil {
+		return err
+	}
+
+	upload, err := uploads.Staging.Append(c.UserContext(), c.Params("id"), offset, c.Body())
+	switch {
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		return apierror.New(apierror.CodeConflict, "Upload-Offset does not match the upload, send HEAD to find it")
+	case errors.Is(err, uploads.ErrTooLarge):
+		return apierror.New(apierror.CodePayloadTooLarge, "Chunk goes past Upload-Length")
+	case errors.Is(err, uploads.ErrNotFound):
+		return apierror.New(apierror.CodeNotFound, "Upload not found")
+	case err != nil:
+		log.Printf("Error appending to upload %s: %v", c.Params("id"), err)
+		return apierror.New(apierror.CodeInternal, "Error storing chunk")
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// A finished upload whose job could not be started gets another try on
+	// each PATCH, which may be empty.
+	if upload.Complete() && upload.JobId == "" {
+		jobID, err := finishTusUpload(c, upload)
+		if err != nil {
+			return err
+		}
+		c.Set("X-Job-ID", jobID)
+		c.Location("/api/jobs/" + jobID)
+	} else if upload.JobId != "" {
+		c.Set("X-Job-ID", upload.JobId)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// finishTusUpload starts the scan job for a complete upload and then deletes
+// the upload's bytes. Only one PATCH starts it, even when several finish at
+// once; the others get its ID.
+func finishTusUpload(c *fiber.Ctx, upload *uploads.Upload) (string, error) {
+	reader, err := uploads.Staging.Open(c.UserContext(), upload.ID)
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", upload.ID, err)
+		return "", apierror.New(apierror.CodeInternal, "Error reading upload")
+	}
+	defer reader.Close()
+
+	data := bytes.NewBuffer(make([]byte, 0, upload.Length))
+	if _, err := io.Copy(data, reader); err != nil || int64(data.Len()) != upload.Length {
+		log.Printf("Error reading upload %s: read %d of %d bytes: %v", upload.ID, data.Len(), upload.Length, err)
+		return "", apierror.New(apierror.CodeInternal, "Error reading upload")
+	}
+
+	jobID := uuid.NewString()
+	claimed, err := uploads.Staging.ClaimJob(c.UserContext(), upload.ID, jobID)
+	if err != nil {
+		log.Printf("Error claiming upload %s: %v", upload.ID, err)
+		return "", apierror.New(apierror.CodeInternal, "Error completing upload")
+	}
+	if claimed != jobID {
+		return claimed, nil
+	}
+	if _, err := startScanJob(jobID, upload.UserId, upload.Filename, upload.ContentType, data.Bytes()); err != nil {
+		if err := uploads.Staging.ReleaseJob(context.Background(), upload.ID, jobID); err != nil {
+			log.Printf("Error releasing upload %s: %v", upload.ID, err)
+		}
+		return "", err
+	}
+	// The job has its own copy now. The raw bytes still hold the upload's
+	// metadata, so they are not kept until the upload expires; its state
+	// stays for HEAD.
+	if err := uploads.Staging.DeleteData(context.Background(), upload.ID); err != nil {
+		log.Printf("Error deleting data of upload %s: %v", upload.ID, err)
+	}
+	return jobID, nil
+}
+
+func deleteTusUpload(c *fiber.Ctx) error {
+	if _, err := ownedUpload(c); err != nil {
+		return err
+	}
+	if err := uploads.Staging.Delete(c.UserContext(), c.Params("id")); err != nil {
+		log.Printf("Error deleting upload %s: %v", c.Params("id"), err)
+		return apierror.New(apierror.CodeInternal, "Error deleting upload")
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// ownedUpload returns the upload named in the path. Other users' uploads are
+// reported as missing.
+func ownedUpload(c *fiber.Ctx) (*uploads.Upload, error) {
+	upload, err := uploads.Staging.Get(c.UserContext(), c.Params("id"))
+	if errors.Is(err, uploads.ErrNotFound) || (err == nil && upload.UserId != c.Locals("user_id").(string)) {
+		return nil, apierror.New(apierror.CodeNotFound, "Upload not found")
+	}
+	if err != nil {
+		log.Printf("Error getting upload %s: %v", c.Params("id"), err)
+		return nil, apierror.New(apierror.CodeInternal, "Error getting upload")
+	}
+	return upload, nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+// of a key and a base64 value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,193 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"api-gateway/apierror"
+	"api-gateway/middleware"
+	"api-gateway/models"
+	"api-gateway/uploads"
+	"api-gateway/utils"
+	"api-gateway/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// uploadURLTTL is how long a signed upload URL stays valid, UPLOAD_URL_TTL.
+var uploadURLTTL = 15 * time.Minute
+
+// uploadCompleteGrace is how long after its URL expires an upload can still
+// be completed, for a PUT that started just before.
+const uploadCompleteGrace = 10 * time.Minute
+
+// setupDirectUploadRoutes lets clients upload images straight to Cloud
+// Storage. POST /api/uploads declares the image and returns a signed PUT URL
+// for it; once the PUT has succeeded, POST /api/uploads/:id/complete checks
+// the stored object against the declaration and starts its scan job. The
+// image lands in the same staging area the gateway uses for other uploads, so
+// the image-upload-service handles it like any other.
+func setupDirectUploadRoutes(api fiber.Router, auth fiber.Handler, uploadLimit fiber.Handler) {
+	if ttl := os.Getenv("UPLOAD_URL_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil || parsed <= 0 || parsed > 7*24*time.Hour {
+			log.Fatalf("Invalid UPLOAD_URL_TTL %q", ttl)
+		}
+		uploadURLTTL = parsed
+	}
+
+	api.Post("/uploads", auth, middleware.Idempotent(), uploadLimit, createDirectUpload)
+	api.Post("/uploads/:id/complete", auth, completeDirectUpload)
+}
+
+func createDirectUpload(c *fiber.Ctx) error {
+	var request models.DirectUploadRequest
+	errs, err := validation.Decode(c.Body(), &request)
+	if err != nil {
+		return apierror.New(apierror.CodeInvalidRequest, "Invalid request body")
+	}
+	if request.ContentType != "" && !strings.HasPrefix(request.ContentType, "image/") {
+		errs.Add("content_type", validation.CodeNotAllowed, "content_type must be an image type")
+	}
+	if request.Size > uploads.MaxSize {
+		errs.Add("size", validation.CodeTooLarge, "size must be at most %d", uploads.MaxSize)
+	}
+	if len(request.SHA256) == 64 && strings.Trim(strings.ToLower(request.SHA256), "0123456789abcdef") != "" {
+		errs.Add("sha256", validation.CodeInvalidFormat, "sha256 must be a hex SHA-256 checksum")
+	}
+	if len(errs) > 0 {
+		return apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs)
+	}
+
+	now := time.Now()
+	upload := &models.DirectUpload{
+		ID:          uuid.NewString(),
+		UserId:      c.Locals("user_id").(string),
+		Filename:    request.Filename,
+		ContentType: request.ContentType,
+		Size:        request.Size,
+		SHA256:      strings.ToLower(request.SHA256),
+		Bucket:      utils.StagingBucket(),
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(uploadURLTTL).Unix(),
+	}
+	upload.Object = utils.StagedImageObject(upload.ID)
+
+	url, headers, err := utils.SignImageUpload(upload.Object, upload.ContentType, upload.Size, now.Add(uploadURLTTL))
+	if err != nil {
+		log.Printf("Error signing upload URL: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error creating upload")
+	}
+	if err := utils.CreateDirectUpload(upload); err != nil {
+		log.Printf("Error creating upload: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error creating upload")
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"upload_id":  upload.ID,
+		"object":     upload.Object,
+		"method":     http.MethodPut,
+		"url":        url,
+		"headers":    headers,
+		"expires_at": upload.ExpiresAt,
+	})
+}
+
+func completeDirectUpload(c *fiber.Ctx) error {
+	uid := c.Locals("user_id").(string)
+	upload, err := utils.GetDirectUpload(c.Params("id"))
+	if err != nil {
+		log.Printf("Error getting upload: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error getting upload")
+	}
+	// Other users' uploads are reported as missing rather than forbidden.
+	if upload == nil || upload.UserId != uid {
+		return apierror.New(apierror.CodeNotFound, "Upload not found")
+	}
+	if upload.JobId != "" {
+		return scanJobAccepted(c, upload.JobId)
+	}
+
+	message := &models.ImageUploadMessage{
+		UserId:      uid,
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+		Bucket:      upload.Bucket,
+		Object:      upload.Object,
+		Size:        upload.Size,
+		SHA256:      upload.SHA256,
+	}
+	if time.Now().After(time.Unix(upload.ExpiresAt, 0).Add(uploadCompleteGrace)) {
+		if err := utils.DeleteStagedImage(context.Background(), message); err != nil {
+			log.Printf("Error deleting staged image for upload %s: %v", upload.ID, err)
+		}
+		return apierror.New(apierror.CodeExpired, "The upload has expired, start a new one")
+	}
+	err = utils.VerifyStagedImage(context.Background(), message)
+	switch {
+	case errors.Is(err, utils.ErrStagedImageMissing):
+		return apierror.New(apierror.CodeConflict, "The image has not been uploaded yet")
+	case errors.Is(err, utils.ErrStagedImageMismatch):
+		// Drop the object so the client can upload it again.
+		log.Printf("Upload %s does not match its declaration: %v", upload.ID, err)
+		if err := utils.DeleteStagedImage(context.Background(), message); err != nil {
+			log.Printf("Error deleting staged image for upload %s: %v", upload.ID, err)
+		}
+		return apierror.New(apierror.CodeInvalidRequest, "The uploaded image does not match the declared size, content type or checksum")
+	case err != nil:
+		log.Printf("Error verifying upload %s: %v", upload.ID, err)
+		return apierror.New(apierror.CodeUnavailable, "Error verifying upload")
+	}
+
+	// Only one completion starts a job, even if the client retries while
+	// the first is still running.
+	jobID := uuid.NewString()
+	claimed, err := utils.ClaimDirectUpload(upload.ID, jobID)
+	if err != nil {
+		log.Printf("Error claiming upload %s: %v", upload.ID, err)
+		return apierror.New(apierror.CodeInternal, "Error completing upload")
+	}
+	if claimed != jobID {
+		return scanJobAccepted(c, claimed)
+	}
+	message.JobId = jobID
+	if _, err := createScanJob(jobID, uid, upload.Filename); err != nil {
+		releaseDirectUpload(upload.ID)
+		return err
+	}
+	if err := publishScanJob(message); err != nil {
+		releaseDirectUpload(upload.ID)
+		return err
+	}
+	return scanJobAccepted(c, jobID)
+}
+
+// scanJobAccepted answers like POST /api/image-upload.
+func scanJobAccepted(c *fiber.Ctx, jobID string) error {
+	job, err := utils.GetJob(jobID)
+	if err != nil {
+		log.Printf("Error getting job: %v", err)
+		return apierror.New(apierror.CodeInternal, "Error getting job")
+	}
+	status := models.JobQueued
+	if job != nil {
+		status = job.Status
+	}
+	c.Location("/api/jobs/" + jobID)
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"job_id": jobID,
+		"status": status,
+	})
+}
+
+func releaseDirectUpload(id string) {
+	if err := utils.ReleaseDirectUpload(id); err != nil {
+		log.Printf("Error releasing upload %s: %v", id, err)
+	}
+}
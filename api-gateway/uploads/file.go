@@ -0,0 +1,235 @@
+package uploads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps each upload as two files in a directory: <id>.json for its
+// state and <id>.bin for its bytes. Uploads are only visible to the gateway
+// that holds the directory.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id, extension string) (string, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id+extension), nil
+}
+
+func (s *FileStore) Create(_ context.Context, upload *Upload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.path(upload.ID, ".bin")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(data, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return s.writeInfo(upload)
+}
+
+func (s *FileStore) Get(_ context.Context, id string) (*Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readInfo(id)
+}
+
+func (s *FileStore) Append(_ context.Context, id string, offset int64, chunk []byte) (*Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+	if upload.Offset+int64(len(chunk)) > upload.Length {
+		return upload, ErrTooLarge
+	}
+	if len(chunk) == 0 {
+		return upload, nil
+	}
+
+	data, err := s.path(id, ".bin")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(data, os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.WriteAt(chunk, offset)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload.Offset += int64(len(chunk))
+	return upload, s.writeInfo(upload)
+}
+
+func (s *FileStore) Open(_ context.Context, id string) (io.ReadCloser, error) {
+	data, err := s.path(id, ".bin")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(data)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *FileStore) ClaimJob(_ context.Context, id, jobID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.readInfo(id)
+	if err != nil {
+		return "", err
+	}
+	if upload.JobId != "" {
+		return upload.JobId, nil
+	}
+	upload.JobId = jobID
+	return jobID, s.writeInfo(upload)
+}
+
+func (s *FileStore) ReleaseJob(_ context.Context, id, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.readInfo(id)
+	if err != nil || upload.JobId != jobID {
+		return err
+	}
+	upload.JobId = ""
+	return s.writeInfo(upload)
+}
+
+func (s *FileStore) DeleteData(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.path(id, ".bin")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, extension := range []string{".bin", ".json"} {
+		path, err := s.path(id, extension)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Expired(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		id, isInfo := strings.CutSuffix(entry.Name(), ".json")
+		if !isInfo {
+			continue
+		}
+		upload, err := s.readStoredInfo(id)
+		if err == nil && now.After(upload.ExpiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// readInfo reads an upload's state, treating expired uploads as missing.
+func (s *FileStore) readInfo(id string) (*Upload, error) {
+	upload, err := s.readStoredInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return upload, nil
+}
+
+func (s *FileStore) readStoredInfo(id string) (*Upload, error) {
+	path, err := s.path(id, ".json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// writeInfo replaces an upload's state file in one step.
+func (s *FileStore) writeInfo(upload *Upload) error {
+	path, err := s.path(upload.ID, ".json")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
@@ -0,0 +1,103 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStoreClaimJob(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	upload := &Upload{ID: "upload-1", UserId: "user-1", Length: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Create(ctx, upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// Of many completions at once, exactly one wins and the rest see its job.
+	var wg sync.WaitGroup
+	claimed := make([]string, 10)
+	for i := range claimed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			jobID, err := store.ClaimJob(ctx, upload.ID, "job-"+strconv.Itoa(i))
+			if err != nil {
+				t.Error(err)
+			}
+			claimed[i] = jobID
+		}(i)
+	}
+	wg.Wait()
+
+	winners := 0
+	for i, jobID := range claimed {
+		if jobID != claimed[0] {
+			t.Fatalf("claims returned %q and %q", claimed[0], jobID)
+		}
+		if jobID == "job-"+strconv.Itoa(i) {
+			winners++
+		}
+	}
+	if winners != 1 {
+		t.Fatalf("%d claims won, want 1", winners)
+	}
+
+	// Releasing another job leaves the claim alone; releasing the winner
+	// frees it for a retry.
+	if err := store.ReleaseJob(ctx, upload.ID, "job-other"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.Get(ctx, upload.ID); stored.JobId != claimed[0] {
+		t.Fatalf("JobId = %q after releasing another job, want %q", stored.JobId, claimed[0])
+	}
+	if err := store.ReleaseJob(ctx, upload.ID, claimed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if jobID, err := store.ClaimJob(ctx, upload.ID, "job-retry"); err != nil || jobID != "job-retry" {
+		t.Fatalf("ClaimJob() after release = %q, %v, want job-retry", jobID, err)
+	}
+}
+
+func TestFileStoreDeleteData(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	upload := &Upload{ID: "upload-1", UserId: "user-1", Length: 4, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Create(ctx, upload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(ctx, upload.ID, 0, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ClaimJob(ctx, upload.ID, "job-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteData(ctx, upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, upload.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after DeleteData error = %v, want ErrNotFound", err)
+	}
+	// The state stays, so HEAD still reports the job, and an empty PATCH
+	// to a finished upload still succeeds.
+	stored, err := store.Get(ctx, upload.ID)
+	if err != nil || stored.JobId != "job-1" || !stored.Complete() {
+		t.Fatalf("Get() after DeleteData = %+v, %v", stored, err)
+	}
+	if _, err := store.Append(ctx, upload.ID, 4, nil); err != nil {
+		t.Errorf("empty Append() after DeleteData error = %v", err)
+	}
+	if err := store.DeleteData(ctx, upload.ID); err != nil {
+		t.Errorf("second DeleteData() error = %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package uploads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// GCSStore keeps uploads in a Cloud Storage bucket under a prefix. Each upload
+// is a folder holding info.json, its state, and one object per chunk named by
+// the chunk's offset. Every gateway replica sees the same uploads, and
+// preconditions on the objects keep two replicas from taking the same offset.
+type GCSStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+func NewGCSStore(bucket *storage.BucketHandle, prefix string) (*GCSStore, error) {
+	if _, err := bucket.Attrs(context.Background()); err != nil {
+		return nil, fmt.Errorf("checking staging bucket: %w", err)
+	}
+	return &GCSStore{bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *GCSStore) folder(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return "", ErrNotFound
+	}
+	return s.prefix + id + "/", nil
+}
+
+func (s *GCSStore) Create(ctx context.Context, upload *Upload) error {
+	folder, err := s.folder(upload.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeInfo(ctx, s.bucket.Object(folder+"info.json").If(storage.Conditions{DoesNotExist: true}), upload)
+}
+
+func (s *GCSStore) Get(ctx context.Context, id string) (*Upload, error) {
+	upload, _, err := s.readInfo(ctx, id)
+	return upload, err
+}
+
+func (s *GCSStore) Append(ctx context.Context, id string, offset int64, chunk []byte) (*Upload, error) {
+	upload, generation, err := s.readInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+	if upload.Offset+int64(len(chunk)) > upload.Length {
+		return upload, ErrTooLarge
+	}
+	if len(chunk) == 0 {
+		return upload, nil
+	}
+
+	folder, _ := s.folder(id)
+	chunkObject := s.bucket.Object(fmt.Sprintf("%schunks/%020d", folder, offset))
+	writer := chunkObject.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := writer.Write(chunk); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return upload, ErrOffsetMismatch
+		}
+		return nil, err
+	}
+
+	upload.Offset += int64(len(chunk))
+	info := s.bucket.Object(folder + "info.json").If(storage.Conditions{GenerationMatch: generation})
+	if err := s.writeInfo(ctx, info, upload); err != nil {
+		// Someone else moved the upload on; our chunk does not count.
+		chunkObject.Delete(context.Background())
+		if isPreconditionFailed(err) {
+			return nil, ErrOffsetMismatch
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (s *GCSStore) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	folder, err := s.folder(id)
+	if err != nil {
+		return nil, err
+	}
+	// Object names sort by offset because the offsets are zero padded.
+	var names []string
+	objects := s.bucket.Objects(ctx, &storage.Query{Prefix: folder + "chunks/"})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+	return &chunkReader{ctx: ctx, bucket: s.bucket, names: names}, nil
+}
+
+// ClaimJob writes the job ID only over the info object it read, and reads
+// again when another replica wrote in between.
+func (s *GCSStore) ClaimJob(ctx context.Context, id, jobID string) (string, error) {
+	for {
+		upload, generation, err := s.readInfo(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if upload.JobId != "" {
+			return upload.JobId, nil
+		}
+		folder, _ := s.folder(id)
+		upload.JobId = jobID
+		err = s.writeInfo(ctx, s.bucket.Object(folder+"info.json").If(storage.Conditions{GenerationMatch: generation}), upload)
+		if !isPreconditionFailed(err) {
+			return jobID, err
+		}
+	}
+}
+
+func (s *GCSStore) ReleaseJob(ctx context.Context, id, jobID string) error {
+	upload, generation, err := s.readInfo(ctx, id)
+	if err != nil || upload.JobId != jobID {
+		return err
+	}
+	folder, _ := s.folder(id)
+	upload.JobId = ""
+	return s.writeInfo(ctx, s.bucket.Object(folder+"info.json").If(storage.Conditions{GenerationMatch: generation}), upload)
+}
+
+func (s *GCSStore) DeleteData(ctx context.Context, id string) error {
+	folder, err := s.folder(id)
+	if err != nil {
+		return err
+	}
+	return s.deletePrefix(ctx, folder+"chunks/")
+}
+
+func (s *GCSStore) Delete(ctx context.Context, id string) error {
+	folder, err := s.folder(id)
+	if err != nil {
+		return err
+	}
+	return s.deletePrefix(ctx, folder)
+}
+
+func (s *GCSStore) deletePrefix(ctx context.Context, prefix string) error {
+	objects := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.bucket.Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+}
+
+// Expired finds expired uploads from the expires_at metadata on their info
+// objects, without reading them.
+func (s *GCSStore) Expired(ctx context.Context, now time.Time) ([]string, error) {
+	var ids []string
+	objects := s.bucket.Objects(ctx, &storage.Query{Prefix: s.prefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, isInfo := strings.CutSuffix(strings.TrimPrefix(attrs.Name, s.prefix), "/info.json")
+		if !isInfo {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, attrs.Metadata["expires_at"])
+		if err == nil && now.After(expiresAt) {
+			ids = append(ids, id)
+		}
+	}
+}
+
+// readInfo reads an upload's state and the generation it was read at,
+// treating expired uploads as missing.
+func (s *GCSStore) readInfo(ctx context.Context, id string) (*Upload, int64, error) {
+	folder, err := s.folder(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, err := s.bucket.Object(folder + "info.json").NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	upload := &Upload{}
+	if err := json.NewDecoder(reader).Decode(upload); err != nil {
+		return nil, 0, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, 0, ErrNotFound
+	}
+	return upload, reader.Attrs.Generation, nil
+}
+
+func (s *GCSStore) writeInfo(ctx context.Context, object *storage.ObjectHandle, upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	writer := object.NewWriter(ctx)
+	writer.ContentType = "application/json"
+	writer.Metadata = map[string]string{"expires_at": upload.ExpiresAt.UTC().Format(time.RFC3339)}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 412
+}
+
+// chunkReader reads an upload's chunk objects one after another.
+type chunkReader struct {
+	ctx     context.Context
+	bucket  *storage.BucketHandle
+	names   []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.names) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := r.bucket.Object(r.names[0]).NewReader(r.ctx)
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.names = reader, r.names[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
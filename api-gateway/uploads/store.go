@@ -0,0 +1,152 @@
+// Package uploads stages resumable uploads until they are complete. The
+// gateway's tus routes drive it; where the bytes are kept is up to the Store.
+package uploads
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"api-gateway/utils"
+)
+
+// Upload is the state of one resumable upload.
+type Upload struct {
+	ID     string `json:"id"`
+	UserId string `json:"user_id"`
+	// Length is the total size declared when the upload was created.
+	Length int64 `json:"length"`
+	// Offset is how many bytes have been received.
+	Offset int64 `json:"offset"`
+	// Metadata is the client's Upload-Metadata header, kept as sent.
+	Metadata    string    `json:"metadata,omitempty"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// JobId is claimed once the upload is complete, as its scan job starts.
+	JobId string `json:"job_id,omitempty"`
+}
+
+// Complete reports whether every byte has been received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrTooLarge       = errors.New("chunk goes past the upload length")
+)
+
+// Store keeps uploads and their bytes.
+type Store interface {
+	Create(ctx context.Context, upload *Upload) error
+	// Get returns ErrNotFound for unknown or expired uploads.
+	Get(ctx context.Context, id string) (*Upload, error)
+	// Append writes data at offset, which must be the upload's current
+	// offset, and returns the updated upload.
+	Append(ctx context.Context, id string, offset int64, data []byte) (*Upload, error)
+	// Open reads back the bytes received so far.
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	// ClaimJob records jobID as the scan job of a complete upload unless it
+	// already has one, and returns the upload's job ID either way. Only the
+	// caller whose jobID comes back should start the job.
+	ClaimJob(ctx context.Context, id, jobID string) (string, error)
+	// ReleaseJob clears the upload's scan job if it is still jobID, so that
+	// starting it can be retried.
+	ReleaseJob(ctx context.Context, id, jobID string) error
+	// DeleteData removes the bytes of an upload whose job has started,
+	// keeping its state so that HEAD still reports the job.
+	DeleteData(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
+	// Expired returns the IDs of uploads whose ExpiresAt has passed.
+	Expired(ctx context.Context, now time.Time) ([]string, error)
+}
+
+var (
+	// Staging is the store used by the tus routes, set up by InitStore.
+	Staging Store
+	// TTL is how long an upload may go without being completed.
+	TTL = 24 * time.Hour
+	// MaxSize is the largest upload accepted, in bytes.
+	MaxSize int64 = 20 << 20
+)
+
+// multipartOverhead is room for the boundary, headers and other fields of a
+// multipart upload on top of the file itself.
+const multipartOverhead = 1 << 20
+
+// BodyLimit is the largest request body the gateway reads, in bytes: a whole
+// upload of MaxSize, whether sent as one tus chunk or as a multipart form.
+func BodyLimit() int {
+	return int(MaxSize) + multipartOverhead
+}
+
+// InitStore selects the store named by TUS_STORE: file (the default) keeps
+// uploads under TUS_STAGING_DIR on this gateway's disk, and gcs keeps them in
+// TUS_STAGING_BUCKET (BUCKET_NAME by default) so any gateway replica can take
+// the next chunk. TUS_UPLOAD_TTL and TUS_MAX_SIZE override TTL and MaxSize.
+// It also starts removing expired uploads.
+func InitStore() {
+	if ttl := os.Getenv("TUS_UPLOAD_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("Invalid TUS_UPLOAD_TTL %q", ttl)
+		}
+		TTL = parsed
+	}
+	if size := os.Getenv("TUS_MAX_SIZE"); size != "" {
+		parsed, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("Invalid TUS_MAX_SIZE %q", size)
+		}
+		MaxSize = parsed
+	}
+
+	var err error
+	switch os.Getenv("TUS_STORE") {
+	case "", "file":
+		dir := os.Getenv("TUS_STAGING_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "tus")
+		}
+		Staging, err = NewFileStore(dir)
+	case "gcs":
+		bucket := os.Getenv("TUS_STAGING_BUCKET")
+		if bucket == "" {
+			bucket = os.Getenv("BUCKET_NAME")
+		}
+		Staging, err = NewGCSStore(utils.StorageClient.Bucket(bucket), "tus/")
+	default:
+		log.Fatalf("Unknown TUS_STORE %q", os.Getenv("TUS_STORE"))
+	}
+	if err != nil {
+		log.Fatalf("Error initializing upload staging store: %v", err)
+	}
+
+	go removeExpired(Staging)
+}
+
+// removeExpired deletes abandoned uploads every few minutes.
+func removeExpired(store Store) {
+	for range time.Tick(5 * time.Minute) {
+		ids, err := store.Expired(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Error listing expired uploads: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := store.Delete(context.Background(), id); err != nil {
+				log.Printf("Error deleting expired upload %s: %v", id, err)
+				continue
+			}
+			log.Printf("Deleted expired upload %s", id)
+		}
+	}
+}
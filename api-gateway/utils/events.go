@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// eventTopics carry per-user events keyed by UID. Each message is pushed to the
+// streaming connections of the user it is keyed by.
+var eventTopics = []string{
+	"image-processing-response",
+	"high-score-update-response",
+	"job-status",
+}
+
+const (
+	// eventHistorySize is how many recent events are kept per user so a client
+	// that reconnects with Last-Event-ID can catch up.
+	eventHistorySize = 50
+	// eventHistoryTTL is how long the history of a user with no open
+	// connections is kept after their last event.
+	eventHistoryTTL = 10 * time.Minute
+	// subscriptionBuffer is how many events may queue for a slow connection
+	// before it is dropped; the client can then resume from its last event.
+	subscriptionBuffer = 16
+)
+
+// Events is the gateway's per-user event hub, fed by the reply dispatcher.
+var Events = NewEventHub()
+
+type Event struct {
+	// ID is derived from the Kafka coordinates of the message, so it is the
+	// same on every gateway instance and a client may resume on any of them.
+	ID    string
+	Topic string
+	Data  []byte
+}
+
+type Subscription struct {
+	Events <-chan Event
+
+	uid    string
+	events chan Event
+}
+
+type userEvents struct {
+	subscriptions map[*Subscription]struct{}
+	history       []Event
+	lastEvent     time.Time
+}
+
+// EventHub fans out events to every connection a user has open.
+type EventHub struct {
+	mu    sync.Mutex
+	users map[string]*userEvents
+}
+
+func NewEventHub() *EventHub {
+	hub := &EventHub{users: make(map[string]*userEvents)}
+	go hub.pruneHistory()
+	return hub
+}
+
+// Subscribe opens a subscription for uid. It returns the buffered events that
+// came after lastEventID; when lastEventID is unknown to this hub every
+// buffered event is returned and the client is expected to skip the ones it
+// has already seen.
+func (h *EventHub) Subscribe(uid, lastEventID string) (*Subscription, []Event) {
+	events := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{Events: events, uid: uid, events: events}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user := h.user(uid)
+	user.subscriptions[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil
+	}
+	missed := user.history
+	for i, event := range user.history {
+		if event.ID == lastEventID {
+			missed = user.history[i+1:]
+			break
+		}
+	}
+	return sub, append([]Event(nil), missed...)
+}
+
+// Unsubscribe closes the subscription. It is safe to call more than once.
+func (h *EventHub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, exists := h.users[sub.uid]
+	if !exists {
+		return
+	}
+	if _, subscribed := user.subscriptions[sub]; subscribed {
+		delete(user.subscriptions, sub)
+		close(sub.events)
+	}
+}
+
+// Publish records the event in the user's history and delivers it to each of
+// their open subscriptions.
+func (h *EventHub) Publish(uid string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user := h.user(uid)
+	user.history = append(user.history, event)
+	if len(user.history) > eventHistorySize {
+		user.history = user.history[len(user.history)-eventHistorySize:]
+	}
+	user.lastEvent = time.Now()
+
+	for sub := range user.subscriptions {
+		select {
+		case sub.events <- event:
+		default:
+			// Drop connections that cannot keep up rather than block the
+			// dispatcher for everyone else.
+			delete(user.subscriptions, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (h *EventHub) user(uid string) *userEvents {
+	user, exists := h.users[uid]
+	if !exists {
+		user = &userEvents{subscriptions: make(map[*Subscription]struct{})}
+		h.users[uid] = user
+	}
+	return user
+}
+
+func (h *EventHub) pruneHistory() {
+	for range time.Tick(time.Minute) {
+		h.mu.Lock()
+		for uid, user := range h.users {
+			if len(user.subscriptions) == 0 && time.Since(user.lastEvent) > eventHistoryTTL {
+				delete(h.users, uid)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+func isEventTopic(topic string) bool {
+	for _, eventTopic := range eventTopics {
+		if topic == eventTopic {
+			return true
+		}
+	}
+	return false
+}
+
+func publishEvent(message *sarama.ConsumerMessage) {
+	uid := string(message.Key)
+	if uid == "" {
+		return
+	}
+	Events.Publish(uid, Event{
+		ID:    fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset),
+		Topic: message.Topic,
+		Data:  message.Value,
+	})
+}
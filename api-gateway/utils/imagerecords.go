@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"api-gateway/models"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetImage returns nil without an error when the image does not exist.
+func GetImage(id string) (*models.Image, error) {
+	doc, err := FirestoreClient.Collection("images").Doc(id).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return imageFromDoc(doc)
+}
+
+// ListImages returns a user's most recent images, newest first, only those
+// with status when it is set.
+func ListImages(uid string, imageStatus models.ImageStatus, limit int) ([]models.Image, error) {
+	query := FirestoreClient.Collection("images").Where("UserId", "==", uid)
+	if imageStatus != "" {
+		query = query.Where("Status", "==", imageStatus)
+	}
+	iter := query.OrderBy("CreatedAt", firestore.Desc).Limit(limit).Documents(context.Background())
+	defer iter.Stop()
+
+	images := []models.Image{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		image, err := imageFromDoc(doc)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+	return images, nil
+}
+
+// imageFromDoc reads an image document. Images scored before statuses were
+// recorded have none and are reported as scored.
+func imageFromDoc(doc *firestore.DocumentSnapshot) (*models.Image, error) {
+	var image models.Image
+	if err := doc.DataTo(&image); err != nil {
+		return nil, err
+	}
+	image.ID = doc.Ref.ID
+	if image.Status == "" {
+		image.Status = models.ImageScored
+	}
+	return &image, nil
+}
+
+// DeleteImage removes an image's stored objects, then its document and its
+// entry in the user's index of scored images, so the same photo can be
+// scored again. The jobs that produced or reused the image lose their copy of
+// its keys, face and score and are marked ImageDeleted. Objects that are
+// already gone are skipped.
+func DeleteImage(image *models.Image) error {
+	ctx := context.Background()
+	bucket := StorageClient.Bucket(os.Getenv("BUCKET_NAME"))
+	keys := []string{image.ImageKey}
+	for _, rendition := range image.Renditions {
+		keys = append(keys, rendition.Key)
+	}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := bucket.Object(key).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+
+	imageRef := FirestoreClient.Collection("images").Doc(image.ID)
+	hashRef := FirestoreClient.Collection("image-hashes").Doc(image.UserId + "_" + image.ContentHash)
+	jobs := FirestoreClient.Collection("jobs")
+	return FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		deleteHash := false
+		if image.ContentHash != "" {
+			doc, err := tx.Get(hashRef)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var entry struct {
+					ImageId string
+				}
+				if err := doc.DataTo(&entry); err != nil {
+					return err
+				}
+				deleteHash = entry.ImageId == image.ID
+			}
+		}
+
+		// The image shares its ID with the job that uploaded it, and jobs
+		// that scored or reused it point at it.
+		jobRefs := map[string]*firestore.DocumentRef{}
+		if _, err := tx.Get(jobs.Doc(image.ID)); err == nil {
+			jobRefs[image.ID] = jobs.Doc(image.ID)
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		jobDocs, err := tx.Documents(jobs.Where("ImageId", "==", image.ID)).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range jobDocs {
+			jobRefs[doc.Ref.ID] = doc.Ref
+		}
+
+		// Firestore transactions do every read before any write.
+		if deleteHash {
+			if err := tx.Delete(hashRef); err != nil {
+				return err
+			}
+		}
+		for _, ref := range jobRefs {
+			if err := tx.Update(ref, []firestore.Update{
+				{Path: "ImageKey", Value: firestore.Delete},
+				{Path: "Renditions", Value: firestore.Delete},
+				{Path: "Face", Value: firestore.Delete},
+				{Path: "Result", Value: firestore.Delete},
+				{Path: "Fingerprint", Value: firestore.Delete},
+				{Path: "ImageDeleted", Value: true},
+				{Path: "UpdatedAt", Value: time.Now().Unix()},
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(imageRef)
+	})
+}
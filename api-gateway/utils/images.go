@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"api-gateway/models"
+
+	"cloud.google.com/go/storage"
+)
+
+// stagedImagePrefix is where uploaded images wait for the image-upload-service.
+// A lifecycle rule on the prefix can clear out any it never picked up.
+const stagedImagePrefix = "staging/"
+
+var (
+	ErrStagedImageMissing  = errors.New("staged image does not exist")
+	ErrStagedImageMismatch = errors.New("staged image does not match")
+)
+
+// StagingBucket is where images are staged: IMAGE_STAGING_BUCKET, or
+// BUCKET_NAME when that is not set.
+func StagingBucket() string {
+	if bucket := os.Getenv("IMAGE_STAGING_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return os.Getenv("BUCKET_NAME")
+}
+
+// StagedImageObject names the staged object for an upload or job ID.
+func StagedImageObject(id string) string {
+	return stagedImagePrefix + id
+}
+
+// StageImage stores an uploaded image for the scan job in message and fills in
+// the message's reference to it.
+func StageImage(ctx context.Context, message *models.ImageUploadMessage, data []byte) error {
+	bucket := StagingBucket()
+	object := StagedImageObject(message.JobId)
+
+	writer := StorageClient.Bucket(bucket).Object(object).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	writer.ContentType = message.ContentType
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(data)
+	message.Bucket = bucket
+	message.Object = object
+	message.Size = int64(len(data))
+	message.SHA256 = hex.EncodeToString(checksum[:])
+	return nil
+}
+
+// DeleteStagedImage removes an image staged for a scan job that never started.
+func DeleteStagedImage(ctx context.Context, message *models.ImageUploadMessage) error {
+	return StorageClient.Bucket(message.Bucket).Object(message.Object).Delete(ctx)
+}
+
+// SignImageUpload returns a URL that lets a client PUT one image of exactly
+// size bytes and contentType to object in the staging bucket until expires,
+// and the headers the client must send with it.
+func SignImageUpload(object, contentType string, size int64, expires time.Time) (string, map[string]string, error) {
+	headers := map[string]string{
+		"Content-Type":                contentType,
+		"X-Goog-Content-Length-Range": fmt.Sprintf("%d,%d", size, size),
+	}
+	url, err := StorageClient.Bucket(StagingBucket()).SignedURL(object, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      "PUT",
+		Expires:     expires,
+		ContentType: contentType,
+		Headers:     []string{"X-Goog-Content-Length-Range:" + headers["X-Goog-Content-Length-Range"]},
+	})
+	return url, headers, err
+}
+
+// VerifyStagedImage checks that the image message points at exists and has
+// the message's size, content type and checksum. It returns
+// ErrStagedImageMissing or ErrStagedImageMismatch when it does not.
+func VerifyStagedImage(ctx context.Context, message *models.ImageUploadMessage) error {
+	object := StorageClient.Bucket(message.Bucket).Object(message.Object)
+	reader, err := object.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ErrStagedImageMissing
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if reader.Attrs.Size != message.Size {
+		return fmt.Errorf("%w: size is %d bytes, expected %d", ErrStagedImageMismatch, reader.Attrs.Size, message.Size)
+	}
+	if reader.Attrs.ContentType != message.ContentType {
+		return fmt.Errorf("%w: content type is %s, expected %s", ErrStagedImageMismatch, reader.Attrs.ContentType, message.ContentType)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != message.SHA256 {
+		return fmt.Errorf("%w: checksum is %s, expected %s", ErrStagedImageMismatch, checksum, message.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"api-gateway/models"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func CreateJob(job *models.Job) error {
+	now := time.Now().Unix()
+	job.Status = models.JobQueued
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	_, err := FirestoreClient.Collection("jobs").Doc(job.ID).Set(context.Background(), job)
+	return err
+}
+
+// GetJob returns nil without an error when the job does not exist.
+func GetJob(id string) (*models.Job, error) {
+	doc, err := FirestoreClient.Collection("jobs").Doc(id).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.Job
+	if err := doc.DataTo(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns a user's most recent jobs, newest first.
+func ListJobs(uid string, limit int) ([]models.Job, error) {
+	iter := FirestoreClient.Collection("jobs").Where("UserId", "==", uid).OrderBy("CreatedAt", firestore.Desc).Limit(limit).Documents(context.Background())
+	defer iter.Stop()
+
+	jobs := []models.Job{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var job models.Job
+		if err := doc.DataTo(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func FailJob(id, reason string) error {
+	_, err := FirestoreClient.Collection("jobs").Doc(id).Update(context.Background(), []firestore.Update{
+		{Path: "Status", Value: models.JobFailed},
+		{Path: "Error", Value: reason},
+		{Path: "UpdatedAt", Value: time.Now().Unix()},
+	})
+	return err
+}
@@ -4,27 +4,52 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
-var consumer sarama.ConsumerGroup
+// CorrelationIDHeader is the Kafka header stamped on every request sent by the
+// gateway. Services copy it onto their reply so the dispatcher can hand the
+// reply back to the request that is waiting for it.
+const CorrelationIDHeader = "correlationID"
 
-func ProduceKafkaMessage(topic string, message interface{}) error {
-	brokers := []string{"kafka:9092"}
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
+// ErrReplyTimeout is returned when no reply arrives before the request deadline.
+var ErrReplyTimeout = errors.New("timeout waiting for reply")
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
-	if err != nil {
-		return err
+// Dispatcher is the gateway's long-lived reply dispatcher, set up by InitKafkaConsumer.
+var Dispatcher *ReplyDispatcher
+
+// ReplyDispatcher consumes every reply topic through a single consumer group
+// session and routes each reply to the request with the matching correlation ID.
+// Messages on the event topics are handed to the Events hub instead.
+type ReplyDispatcher struct {
+	consumer  sarama.ConsumerGroup
+	topics    []string
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex
+	pending map[string]chan *sarama.ConsumerMessage
+}
+
+func NewReplyDispatcher(consumer sarama.ConsumerGroup, topics []string) *ReplyDispatcher {
+	return &ReplyDispatcher{
+		consumer: consumer,
+		topics:   topics,
+		ready:    make(chan struct{}),
+		pending:  make(map[string]chan *sarama.ConsumerMessage),
 	}
-	defer producer.Close()
+}
 
+func ProduceKafkaMessage(topic string, message interface{}) error {
 	msg, err := json.Marshal(message)
 	if err != nil {
 		return err
@@ -34,58 +59,86 @@ func ProduceKafkaMessage(topic string, message interface{}) error {
 		Topic: topic,
 		Value: sarama.StringEncoder(msg),
 	}
-
-	partition, offset, err := producer.SendMessage(kafkaMsg)
-	if err != nil {
-		log.Printf("Error producing message to kafka: %v\n", err)
-		return err
-	}
-
-	log.Printf("Message is stored in topic(%s)/partition(%d)/offset(%d)", topic, partition, offset)
-	return nil
+	return ProduceKafkaMessageWithHeaders(kafkaMsg)
 }
 
-func InitKafkaConsumer() {
+// InitKafkaConsumer starts the reply dispatcher on replyTopics, the topics the
+// services answer on, and waits until it has joined its consumer group, so
+// replies to the first requests are not missed.
+func InitKafkaConsumer(replyTopics []string) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
 
-	var err error
-	consumer, err = sarama.NewConsumerGroup([]string{"kafka:9092"}, "api-gateway-group", config)
+	// Every gateway instance needs to see every reply, since the request may
+	// have been sent by any of them, so each instance gets its own group.
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = uuid.NewString()
+	}
+	consumer, err := sarama.NewConsumerGroup([]string{"kafka:9092"}, "api-gateway-group-"+hostname, config)
 	if err != nil {
 		log.Fatalf("Error creating consumer group: %v", err)
 	}
+
+	topics := append(append([]string{}, replyTopics...), eventTopics...)
+	Dispatcher = NewReplyDispatcher(consumer, topics)
+	go Dispatcher.Run(context.Background())
+
+	select {
+	case <-Dispatcher.ready:
+		log.Println("Reply dispatcher is ready")
+	case <-time.After(30 * time.Second):
+		log.Println("Reply dispatcher is not ready yet, continuing startup")
+	}
 }
 
-func ConsumeKafkaMessage(topic, key string, timeout time.Duration) (*fiber.Map, int, error) {
-	handler := &ConsumerGroupHandler{
-		ready:   make(chan bool),
-		key:     key,
-		message: make(chan *sarama.ConsumerMessage),
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	go func() {
-		for {
-			if err := consumer.Consume(ctx, []string{topic}, handler); err != nil {
-				log.Printf("Error consuming messages: %v", err)
-			}
-			if ctx.Err() != nil {
-				return
-			}
-			handler.ready = make(chan bool)
+// Run consumes the reply topics until ctx is cancelled.
+func (d *ReplyDispatcher) Run(ctx context.Context) {
+	for {
+		if err := d.consumer.Consume(ctx, d.topics, d); err != nil {
+			log.Printf("Error consuming replies: %v", err)
 		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Request stamps msg with a fresh correlation ID, sends it and waits up to
+// timeout for the matching reply. The returned status code comes from the
+// reply's statusCode field, defaulting to 200.
+func (d *ReplyDispatcher) Request(msg *sarama.ProducerMessage, timeout time.Duration) (*fiber.Map, int, error) {
+	correlationID := uuid.NewString()
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{
+		Key:   []byte(CorrelationIDHeader),
+		Value: []byte(correlationID),
+	})
+
+	// Register before producing so a fast reply cannot arrive unclaimed.
+	reply := make(chan *sarama.ConsumerMessage, 1)
+	d.mu.Lock()
+	d.pending[correlationID] = reply
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, correlationID)
+		d.mu.Unlock()
 	}()
 
-	<-handler.ready
+	if err := ProduceKafkaMessageWithHeaders(msg); err != nil {
+		return nil, 0, fmt.Errorf("producing request: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
 	select {
-	case msg := <-handler.message:
-		log.Printf("Message claimed: value = %s, timestamp = %v, topic = %s", string(msg.Value), msg.Timestamp, msg.Topic)
+	case message := <-reply:
+		log.Printf("Reply received: value = %s, timestamp = %v, topic = %s", string(message.Value), message.Timestamp, message.Topic)
 
 		var response fiber.Map
-		if err := json.Unmarshal(msg.Value, &response); err != nil {
+		if err := json.Unmarshal(message.Value, &response); err != nil {
 			return nil, 0, err
 		}
 
@@ -96,53 +149,81 @@ func ConsumeKafkaMessage(topic, key string, timeout time.Duration) (*fiber.Map,
 		}
 
 		return &response, statusCode, nil
-	case <-ctx.Done():
-		return nil, 0, errors.New("timeout waiting for message")
+	case <-timer.C:
+		return nil, 0, ErrReplyTimeout
 	}
 }
 
-type ConsumerGroupHandler struct {
-	ready   chan bool
-	message chan *sarama.ConsumerMessage
-	key     string
+func (d *ReplyDispatcher) dispatch(message *sarama.ConsumerMessage) {
+	correlationID := headerValue(message.Headers, CorrelationIDHeader)
+	if correlationID == "" {
+		return
+	}
+
+	d.mu.Lock()
+	reply, exists := d.pending[correlationID]
+	d.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	// The channel is buffered for exactly one reply; drop duplicates.
+	select {
+	case reply <- message:
+	default:
+	}
 }
 
-func (h *ConsumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error {
-	close(h.ready)
+func (d *ReplyDispatcher) Setup(_ sarama.ConsumerGroupSession) error {
+	d.readyOnce.Do(func() { close(d.ready) })
 	return nil
 }
 
-func (h *ConsumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
+func (d *ReplyDispatcher) Cleanup(_ sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-func (h *ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+func (d *ReplyDispatcher) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
-		if string(message.Key) == h.key {
-			h.message <- message
+		if isEventTopic(message.Topic) {
+			publishEvent(message)
+		} else {
+			d.dispatch(message)
 		}
 		sess.MarkMessage(message, "")
 	}
 	return nil
 }
 
-func ProduceKafkaMessageWithHeaders(msg *sarama.ProducerMessage) error {
-	brokers := []string{"kafka:9092"}
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
-
-	producer, err := sarama.NewSyncProducer(brokers, config)
+// RequestReply marshals message to JSON, sends it to topic with the given key
+// and waits for the reply through the Dispatcher.
+func RequestReply(topic, key string, message interface{}, timeout time.Duration) (*fiber.Map, int, error) {
+	value, err := json.Marshal(message)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	defer producer.Close()
 
-	partition, offset, err := producer.SendMessage(msg)
-	if err != nil {
+	kafkaMsg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+	return Dispatcher.Request(kafkaMsg, timeout)
+}
+
+func headerValue(headers []*sarama.RecordHeader, key string) string {
+	for _, header := range headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func ProduceKafkaMessageWithHeaders(msg *sarama.ProducerMessage) error {
+	if err := KafkaProducer.SendMessage(msg); err != nil {
 		log.Printf("Error producing message to kafka: %v\n", err)
 		return err
 	}
-
-	log.Printf("Message is stored in topic(%s)/partition(%d)/offset(%d)", msg.Topic, partition, offset)
 	return nil
 }
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"api-gateway/models"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrImageNotFlagged is returned when reviewing an image that was never
+// flagged.
+var ErrImageNotFlagged = errors.New("image is not flagged")
+
+// ListFlaggedImages returns up to limit images whose review is pending.
+func ListFlaggedImages(limit int) ([]models.FlaggedImage, error) {
+	iter := FirestoreClient.Collection("images").Where("Review", "==", models.ReviewPending).Limit(limit).Documents(context.Background())
+	defer iter.Stop()
+
+	images := []models.FlaggedImage{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var image models.FlaggedImage
+		if err := doc.DataTo(&image); err != nil {
+			return nil, err
+		}
+		image.ID = doc.Ref.ID
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// ReviewImage records a moderator's decision on a flagged image. It returns
+// nil without an error when the image does not exist.
+func ReviewImage(id, decision, reviewer string) (*models.FlaggedImage, error) {
+	ref := FirestoreClient.Collection("images").Doc(id)
+	var image *models.FlaggedImage
+	err := FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		image = nil
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		image = &models.FlaggedImage{}
+		if err := doc.DataTo(image); err != nil {
+			return err
+		}
+		if image.Review == "" {
+			return ErrImageNotFlagged
+		}
+		image.ID = doc.Ref.ID
+		image.Review = decision
+		image.ReviewedBy = reviewer
+		image.ReviewedAt = time.Now().Unix()
+		return tx.Update(ref, []firestore.Update{
+			{Path: "Review", Value: image.Review},
+			{Path: "ReviewedBy", Value: image.ReviewedBy},
+			{Path: "ReviewedAt", Value: image.ReviewedAt},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// RecomputeHighScore sets a user's high score to their best scored image that
+// is not rejected or awaiting review, or 0 when there is none. Flagged images
+// count only once they are approved, as on the leaderboards.
+func RecomputeHighScore(uid string) error {
+	ctx := context.Background()
+	iter := FirestoreClient.Collection("images").Where("UserId", "==", uid).OrderBy("TotalScore", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+
+	highScore := 0.0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var image models.FlaggedImage
+		if err := doc.DataTo(&image); err != nil {
+			return err
+		}
+		if image.Review != models.ReviewRejected && image.Review != models.ReviewPending && (image.Status == "" || image.Status == models.ImageScored) {
+			highScore = image.TotalScore
+			break
+		}
+	}
+
+	_, err := FirestoreClient.Collection("users").Doc(uid).Update(ctx, []firestore.Update{
+		{Path: "HighScore", Value: highScore},
+	})
+	return err
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Images are stored privately and only their object keys are kept in
+// Firestore. Anything that shows an image, to a client or to the scoring
+// service, hands out a short-lived signed URL instead. The gateway and the
+// services that do so keep a copy of this file.
+
+// defaultImageURLTTL is how long a signed image URL stays valid unless
+// IMAGE_URL_TTL says otherwise.
+const defaultImageURLTTL = 15 * time.Minute
+
+func imageURLTTL() time.Duration {
+	ttl := os.Getenv("IMAGE_URL_TTL")
+	if ttl == "" {
+		return defaultImageURLTTL
+	}
+	parsed, err := time.ParseDuration(ttl)
+	if err != nil || parsed <= 0 || parsed > 7*24*time.Hour {
+		log.Printf("Invalid IMAGE_URL_TTL %q, using %v", ttl, defaultImageURLTTL)
+		return defaultImageURLTTL
+	}
+	return parsed
+}
+
+// SignedImageURL returns a URL that reads the image stored under key in
+// BUCKET_NAME until it expires. An empty key gives an empty URL.
+func SignedImageURL(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	return StorageClient.Bucket(os.Getenv("BUCKET_NAME")).SignedURL(key, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(imageURLTTL()),
+	})
+}
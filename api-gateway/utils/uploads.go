@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+
+	"api-gateway/models"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func CreateDirectUpload(upload *models.DirectUpload) error {
+	_, err := FirestoreClient.Collection("uploads").Doc(upload.ID).Create(context.Background(), upload)
+	return err
+}
+
+// GetDirectUpload returns nil without an error when the upload does not exist.
+func GetDirectUpload(id string) (*models.DirectUpload, error) {
+	doc, err := FirestoreClient.Collection("uploads").Doc(id).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var upload models.DirectUpload
+	if err := doc.DataTo(&upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// ClaimDirectUpload records jobID as the upload's scan job unless it already
+// has one, and returns the upload's job ID either way. Only the caller whose
+// jobID comes back should start the job.
+func ClaimDirectUpload(id, jobID string) (string, error) {
+	ref := FirestoreClient.Collection("uploads").Doc(id)
+	claimed := jobID
+	err := FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var upload models.DirectUpload
+		if err := doc.DataTo(&upload); err != nil {
+			return err
+		}
+		if upload.JobId != "" {
+			claimed = upload.JobId
+			return nil
+		}
+		claimed = jobID
+		return tx.Update(ref, []firestore.Update{{Path: "JobId", Value: jobID}})
+	})
+	return claimed, err
+}
+
+// ReleaseDirectUpload clears the upload's scan job so completing it can be
+// retried.
+func ReleaseDirectUpload(id string) error {
+	_, err := FirestoreClient.Collection("uploads").Doc(id).Update(context.Background(), []firestore.Update{
+		{Path: "JobId", Value: ""},
+	})
+	return err
+}
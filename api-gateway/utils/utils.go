@@ -20,24 +20,31 @@ var StorageClient *storage.Client
 
 func InitFirebase() {
 	credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	appId := os.Getenv("APP_ID")
 
-	file, err := os.Open(credentialsFile)
-	if err != nil {
-		log.Fatalf("Error opening credentials file: %v", err)
+	var opt option.ClientOption
+	var config *firebase.Config
+	var err error
+	if credentialsFile == "" {
+		// Without credentials the clients can only talk to the local
+		// emulators (FIRESTORE_EMULATOR_HOST, STORAGE_EMULATOR_HOST).
+		fmt.Println("No credentials file set, connecting without authentication")
+		opt = option.WithoutAuthentication()
+		config = &firebase.Config{ProjectID: appId}
+	} else {
+		file, err := os.Open(credentialsFile)
+		if err != nil {
+			log.Fatalf("Error opening credentials file: %v", err)
+		}
+		file.Close()
+		fmt.Println("Credentials file opened successfully")
+		opt = option.WithCredentialsFile(credentialsFile)
 	}
-	file.Close()
-	fmt.Println("Credentials file opened successfully")
 
-	opt := option.WithCredentialsFile(credentialsFile)
-	FirebaseApp, err = firebase.NewApp(context.Background(), nil, opt)
+	FirebaseApp, err = firebase.NewApp(context.Background(), config, opt)
 	if err != nil {
 		log.Fatalf("error initializing firebase app: %v\n", err)
 	}
-	AuthClient, err = FirebaseApp.Auth(context.Background())
-	if err != nil {
-		log.Fatalf("error initializing firebase auth client: %v\n", err)
-	}
-	appId := os.Getenv("APP_ID")
 	FirestoreClient, err = firestore.NewClient(context.Background(), appId, opt)
 	if err != nil {
 		log.Fatalf("error initializing firestore client: %v\n", err)
@@ -48,13 +55,14 @@ func InitFirebase() {
 	}
 }
 
-func ValidateToken(idToken string) (*auth.Token, error) {
-	token, err := AuthClient.VerifyIDToken(context.Background(), idToken)
+// InitFirebaseAuth sets up AuthClient. Only the Firebase token verifier needs it.
+func InitFirebaseAuth() {
+	var err error
+	AuthClient, err = FirebaseApp.Auth(context.Background())
 	if err != nil {
-		return nil, err
+		log.Fatalf("error initializing firebase auth client: %v\n", err)
 	}
-	return token, nil
-} 
+}
 
 func CloseFirestore() {
 	if FirestoreClient != nil {
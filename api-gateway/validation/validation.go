@@ -0,0 +1,282 @@
+// Package validation checks payloads against rules declared in `validate`
+// struct tags. The same file is kept in every service that validates
+// payloads, so a rule means the same thing at the gateway and behind it.
+//
+// Rules are separated by commas:
+//
+//	required   the field must not be empty or zero
+//	readonly   the field is set by the server; clients may not send it
+//	email      the field is an email address
+//	username   the field holds only letters, digits, '_' and '.'
+//	min=N      numbers must be at least N; strings and lists need N items
+//	max=N      numbers must be at most N; strings and lists may have N items
+//	oneof=a b  the field, or every item of a list, is one of the values
+//
+// Fields that are empty and not required are not checked further. Fields are
+// reported by their JSON names.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError describes why one field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes, stable for clients to match on.
+const (
+	CodeRequired      = "required"
+	CodeReadOnly      = "read_only"
+	CodeInvalidType   = "invalid_type"
+	CodeInvalidEmail  = "invalid_email"
+	CodeInvalidFormat = "invalid_format"
+	CodeTooSmall      = "too_small"
+	CodeTooLarge      = "too_large"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeNotAllowed    = "not_allowed"
+)
+
+// Errors is the list of problems found in a payload.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add appends an error for field.
+func (e *Errors) Add(field, code, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Decode unmarshals a client's JSON object into v, a pointer to a struct, and
+// checks it. Read-only fields that are present are rejected and their other
+// rules skipped, since the server fills them in afterwards. A body that is not
+// a JSON object of the right shape returns an error rather than Errors.
+func Decode(data []byte, v interface{}) (Errors, error) {
+	present := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+	if err := json.Unmarshal(data, &present); err != nil {
+		return nil, fmt.Errorf("body is not a JSON object")
+	}
+
+	var errs Errors
+	value := reflect.ValueOf(v).Elem()
+	for _, field := range fields(value.Type()) {
+		if raw, exists := present[field.name]; exists && field.has("readonly") && string(raw) != "null" {
+			errs.Add(field.name, CodeReadOnly, "%s is set by the server", field.name)
+		}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			errs.Add(typeErr.Field, CodeInvalidType, "%s must be %s", typeErr.Field, typeName(typeErr.Type))
+			return errs, nil
+		}
+		return nil, err
+	}
+	return append(errs, check(value, false)...), nil
+}
+
+// Validate checks v, a struct or a pointer to one, including its read-only
+// fields. Services use it on payloads the gateway has already filled in.
+func Validate(v interface{}) Errors {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	return check(value, true)
+}
+
+type field struct {
+	index int
+	name  string
+	rules map[string][]string
+	order []string
+}
+
+func (f field) has(rule string) bool {
+	_, exists := f.rules[rule]
+	return exists
+}
+
+// fields returns the struct's fields that have a validate tag.
+func fields(t reflect.Type) []field {
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		tag := structField.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = structField.Name
+		}
+		f := field{index: i, name: name, rules: map[string][]string{}}
+		for _, rule := range strings.Split(tag, ",") {
+			key, argument, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			f.rules[key] = strings.Fields(argument)
+			f.order = append(f.order, key)
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+func check(value reflect.Value, includeReadOnly bool) Errors {
+	var errs Errors
+	for _, f := range fields(value.Type()) {
+		if f.has("readonly") && !includeReadOnly {
+			continue
+		}
+		fieldValue := value.Field(f.index)
+		if isEmpty(fieldValue) {
+			if f.has("required") {
+				errs.Add(f.name, CodeRequired, "%s is required", f.name)
+			}
+			continue
+		}
+		for _, rule := range f.order {
+			if fieldError := checkRule(f.name, rule, f.rules[rule], fieldValue); fieldError != nil {
+				errs = append(errs, *fieldError)
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func checkRule(name, rule string, arguments []string, value reflect.Value) *FieldError {
+	fail := func(code, format string, args ...interface{}) *FieldError {
+		return &FieldError{Field: name, Code: code, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch rule {
+	case "required", "readonly":
+		return nil
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return fail(CodeInvalidEmail, "%s must be an email address", name)
+		}
+	case "username":
+		for _, r := range value.String() {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+				return fail(CodeInvalidFormat, "%s may only contain letters, digits, '_' and '.'", name)
+			}
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(firstArgument(arguments), 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: bad %s rule on %s", rule, name))
+		}
+		switch value.Kind() {
+		case reflect.String, reflect.Slice:
+			length := value.Len()
+			if value.Kind() == reflect.String {
+				length = utf8.RuneCountInString(value.String())
+			}
+			if rule == "min" && float64(length) < limit {
+				return fail(CodeTooShort, "%s must be at least %v long", name, limit)
+			}
+			if rule == "max" && float64(length) > limit {
+				return fail(CodeTooLong, "%s must be at most %v long", name, limit)
+			}
+		default:
+			number := toFloat(value)
+			if rule == "min" && number < limit {
+				return fail(CodeTooSmall, "%s must be at least %v", name, limit)
+			}
+			if rule == "max" && number > limit {
+				return fail(CodeTooLarge, "%s must be at most %v", name, limit)
+			}
+		}
+	case "oneof":
+		items := []string{value.String()}
+		if value.Kind() == reflect.Slice {
+			items = make([]string, value.Len())
+			for i := range items {
+				items[i] = value.Index(i).String()
+			}
+		}
+		for _, item := range items {
+			if !contains(arguments, item) {
+				return fail(CodeNotAllowed, "%s must be one of %s", name, strings.Join(arguments, ", "))
+			}
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q on %s", rule, name))
+	}
+	return nil
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func toFloat(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	return 0
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice:
+		return "a list"
+	}
+	return "an object"
+}
+
+func firstArgument(arguments []string) string {
+	if len(arguments) == 0 {
+		return ""
+	}
+	return arguments[0]
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+type profile struct {
+	UID      string   `json:"uid" validate:"required,readonly"`
+	Email    string   `json:"email" validate:"required,email"`
+	Username string   `json:"username" validate:"username,min=3,max=8"`
+	Age      int      `json:"age" validate:"min=13,max=120"`
+	Roles    []string `json:"roles" validate:"readonly,oneof=user admin"`
+	Gender   string   `json:"gender" validate:"oneof=male female other"`
+	Bio      string   `json:"bio"`
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Errors
+	}{
+		{
+			name: "valid",
+			body: `{"email": "a@example.com", "username": "alice", "age": 30, "gender": "other", "bio": "hi"}`,
+		},
+		{
+			name: "empty body",
+			body: ``,
+			want: Errors{{Field: "email", Code: CodeRequired, Message: "email is required"}},
+		},
+		{
+			name: "read-only fields",
+			body: `{"uid": "someone-else", "email": "a@example.com", "roles": ["admin"]}`,
+			want: Errors{
+				{Field: "uid", Code: CodeReadOnly, Message: "uid is set by the server"},
+				{Field: "roles", Code: CodeReadOnly, Message: "roles is set by the server"},
+			},
+		},
+		{
+			name: "read-only null",
+			body: `{"uid": null, "email": "a@example.com"}`,
+		},
+		{
+			name: "blank required",
+			body: `{"email": "   "}`,
+			want: Errors{{Field: "email", Code: CodeRequired, Message: "email is required"}},
+		},
+		{
+			name: "wrong type",
+			body: `{"email": "a@example.com", "age": "thirty"}`,
+			want: Errors{{Field: "age", Code: CodeInvalidType, Message: "age must be an integer"}},
+		},
+		{
+			name: "rules",
+			body: `{"email": "not an email", "username": "al", "age": 200, "gender": "unknown"}`,
+			want: Errors{
+				{Field: "email", Code: CodeInvalidEmail, Message: "email must be an email address"},
+				{Field: "username", Code: CodeTooShort, Message: "username must be at least 3 long"},
+				{Field: "age", Code: CodeTooLarge, Message: "age must be at most 120"},
+				{Field: "gender", Code: CodeNotAllowed, Message: "gender must be one of male, female, other"},
+			},
+		},
+		{
+			name: "first failing rule only",
+			body: `{"email": "a@example.com", "username": "bad name!", "age": 5}`,
+			want: Errors{
+				{Field: "username", Code: CodeInvalidFormat, Message: "username may only contain letters, digits, '_' and '.'"},
+				{Field: "age", Code: CodeTooSmall, Message: "age must be at least 13"},
+			},
+		},
+		{
+			name: "long username in runes",
+			body: `{"email": "a@example.com", "username": "ééééééééé"}`,
+			want: Errors{{Field: "username", Code: CodeTooLong, Message: "username must be at most 8 long"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p profile
+			errs, err := Decode([]byte(test.body), &p)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(errs, test.want) {
+				t.Errorf("Decode() = %#v, want %#v", errs, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsNonObjects(t *testing.T) {
+	for _, body := range []string{`[]`, `"email"`, `{`} {
+		var p profile
+		if _, err := Decode([]byte(body), &p); err == nil {
+			t.Errorf("Decode(%s) accepted a body that is not a JSON object", body)
+		}
+	}
+}
+
+func TestValidateChecksReadOnlyFields(t *testing.T) {
+	errs := Validate(&profile{Email: "a@example.com", Roles: []string{"user", "root"}})
+	want := Errors{
+		{Field: "uid", Code: CodeRequired, Message: "uid is required"},
+		{Field: "roles", Code: CodeNotAllowed, Message: "roles must be one of user, admin"},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("Validate() = %#v, want %#v", errs, want)
+	}
+
+	if errs := Validate(profile{UID: "u1", Email: "a@example.com", Roles: []string{"admin"}}); errs != nil {
+		t.Errorf("Validate() = %#v, want no errors", errs)
+	}
+}
@@ -30,6 +30,10 @@ func HandleUserRegistration(user models.User) (int, string) {
 	// 	log.Printf("Username %s already exists", user.Username)
 	// 	return http.StatusConflict, "Username already exists"
 	// }
+	// The high score is only ever set from scan results, and the plan by
+	// an admin.
+	user.HighScore = 0
+	user.Plan = "free"
 	user.CreatedAt = time.Now().Unix()
 	user.Password = utils.HashPassword(user.Password)
 	_, err = utils.FirestoreClient.Collection("users").Doc(user.UID).Set(context.Background(), user)
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"auth-service/models"
+	"auth-service/utils"
+	"context"
+	"log"
+	"net/http"
+
+	"firebase.google.com/go/auth"
+)
+
+// HandleRoleAssignment replaces a user's roles. The roles are stored in the
+// "roles" custom claim, next to any other custom claims the user has, and show
+// up in the user's ID token the next time it is refreshed. The request must
+// already be valid.
+func HandleRoleAssignment(request models.RoleAssignment) (int, string) {
+	user, err := utils.AuthClient.GetUser(context.Background(), request.UID)
+	if auth.IsUserNotFound(err) {
+		return http.StatusNotFound, "User not found"
+	}
+	if err != nil {
+		log.Printf("Error getting user %s: %v", request.UID, err)
+		return http.StatusInternalServerError, "Error getting user"
+	}
+
+	claims := make(map[string]interface{}, len(user.CustomClaims)+1)
+	for name, value := range user.CustomClaims {
+		claims[name] = value
+	}
+	if request.Roles == nil {
+		request.Roles = []string{}
+	}
+	claims["roles"] = request.Roles
+
+	err = utils.AuthClient.SetCustomUserClaims(context.Background(), request.UID, claims)
+	if err != nil {
+		log.Printf("Error setting roles for user %s: %v", request.UID, err)
+		return http.StatusInternalServerError, "Error setting roles"
+	}
+
+	log.Printf("User %s set roles of %s to %v", request.AssignedBy, request.UID, request.Roles)
+	return http.StatusOK, "Roles updated successfully"
+}
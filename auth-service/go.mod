@@ -2,10 +2,12 @@ module auth-service
 
 go 1.22.4
 
-require github.com/gofiber/fiber/v2 v2.52.4
+require (
+	github.com/IBM/sarama v1.43.2
+	github.com/gofiber/fiber/v2 v2.52.4
+)
 
 require (
-	github.com/IBM/sarama v1.43.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/eapache/go-resiliency v1.6.0 // indirect
 	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
@@ -46,7 +48,7 @@ require (
 	go.opentelemetry.io/otel v1.24.0 // indirect
 	go.opentelemetry.io/otel/metric v1.24.0 // indirect
 	go.opentelemetry.io/otel/trace v1.24.0 // indirect
-	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/crypto v0.24.0
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/oauth2 v0.21.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
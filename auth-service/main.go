@@ -1,9 +1,11 @@
 package main
 
 import (
+	"auth-service/apierror"
 	"auth-service/controllers"
 	"auth-service/models"
 	"auth-service/utils"
+	"auth-service/validation"
 	"context"
 	"encoding/json"
 	"log"
@@ -26,6 +28,9 @@ func main(){
 	utils.InitFirebase()
 	defer utils.CloseFirestore()
 
+	utils.InitProducer()
+	defer utils.CloseProducer()
+
 	app := fiber.New()
 
 	app.Use(cors.New(cors.Config{
@@ -36,18 +41,33 @@ func main(){
 
 	app.Get("/health", controllers.HealthCheck)
 
-	go startKafkaConsumer()
+	ctx, stopConsumer := context.WithCancel(context.Background())
+	consumerDone := startKafkaConsumer(ctx)
 
-	log.Fatal(app.Listen(":8080"))
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
 
+	if err := app.Listen(":8080"); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	// Stop consuming before the deferred producer close, so that no handler
+	// sends on a closed producer.
+	stopConsumer()
+	<-consumerDone
 }
 
 
-func startKafkaConsumer() {
+// startKafkaConsumer consumes until ctx is cancelled. The returned channel is
+// closed once the handlers have finished their last messages and the
+// consumer group is closed.
+func startKafkaConsumer(ctx context.Context) <-chan struct{} {
 	brokers := []string{"kafka:9092"}
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
@@ -60,12 +80,18 @@ func startKafkaConsumer() {
 
 	handler := ConsumerGroupHandler{}
 
-	for {
-		err := consumer.Consume(context.Background(), []string{"user-registration"}, handler)
-		if err != nil {
-			log.Printf("Error from consumer: %v", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer consumer.Close()
+		for ctx.Err() == nil {
+			err := consumer.Consume(ctx, []string{"user-registration", "user-role-assign"}, handler)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error from consumer: %v", err)
+			}
 		}
-	}
+	}()
+	return done
 }
 
 type ConsumerGroupHandler struct{}
@@ -74,33 +100,50 @@ func (ConsumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error   { retur
 func (ConsumerGroupHandler)	Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
 func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
-		var user models.User
-		err := json.Unmarshal(msg.Value, &user)
-		if err != nil {
-			log.Printf("Error unmarshalling message: %v", err)
-			continue
+		switch msg.Topic {
+		case "user-registration":
+			var user models.User
+			err := json.Unmarshal(msg.Value, &user)
+			if err != nil {
+				log.Printf("Error unmarshalling message: %v", err)
+				break
+			}
+			response := map[string]interface{}{
+				"email": user.Email,
+			}
+			if errs := validation.Validate(user); len(errs) > 0 {
+				apierror.SetError(response, apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs))
+			} else {
+				statusCode, responseMessage := controllers.HandleUserRegistration(user)
+				apierror.SetReply(response, statusCode, responseMessage)
+			}
+			produceResponseMessage(response, "user-registration-response", user.Email, replyHeaders(msg))
+		case "user-role-assign":
+			var request models.RoleAssignment
+			err := json.Unmarshal(msg.Value, &request)
+			if err != nil {
+				log.Printf("Error unmarshalling message: %v", err)
+				break
+			}
+			response := map[string]interface{}{
+				"uid": request.UID,
+				"roles": request.Roles,
+			}
+			if errs := validation.Validate(request); len(errs) > 0 {
+				apierror.SetError(response, apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs))
+			} else {
+				statusCode, responseMessage := controllers.HandleRoleAssignment(request)
+				apierror.SetReply(response, statusCode, responseMessage)
+			}
+			produceResponseMessage(response, "user-role-assign-response", request.UID, replyHeaders(msg))
 		}
-		statusCode, responseMessage := controllers.HandleUserRegistration(user)
-		response := map[string]interface{}{
-			"message": responseMessage,
-			"email": user.Email,
-			"statusCode": statusCode,
-		}
-		produceResponseMessage(response)
-		
+
 		sess.MarkMessage(msg, "")
 		}
 	return nil
 }
 
-func produceResponseMessage(response map[string]interface{}) { // Add opening curly brace here
-	producer, err := sarama.NewSyncProducer([]string{"kafka:9092"}, nil)
-	if err != nil {
-		log.Printf("Error creating producer: %v", err)
-		return
-	}
-	defer producer.Close()
-
+func produceResponseMessage(response map[string]interface{}, topic, key string, headers []sarama.RecordHeader) {
 	msg, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling response: %v", err)
@@ -108,13 +151,25 @@ func produceResponseMessage(response map[string]interface{}) { // Add opening cu
 	}
 
 	kafkaMsg := &sarama.ProducerMessage{
-		Topic: "user-registration-response",
+		Topic: topic,
 		Value: sarama.StringEncoder(msg),
-		Key:  sarama.StringEncoder(response["email"].(string)),
+		Key:  sarama.StringEncoder(key),
+		Headers: headers,
 	}
 
-	_, _, err = producer.SendMessage(kafkaMsg)
+	err = utils.KafkaProducer.SendMessage(kafkaMsg)
 	if err != nil {
 		log.Printf("Error producing message: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// replyHeaders copies the gateway's correlation ID from a request onto its
+// reply so the gateway can match the two.
+func replyHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	for _, header := range msg.Headers {
+		if string(header.Key) == "correlationID" {
+			return []sarama.RecordHeader{{Key: header.Key, Value: header.Value}}
+		}
+	}
+	return nil
+}
@@ -1,12 +1,22 @@
 package models
 
+// User is a registration request. Its rules match the gateway's
+// models.Registration.
 type User struct {
-	UID			string  `json:"uid"`
-	Email		string 	`json:"email"`
-	Username	string 	`json:"username"`
-	Password	string 	`json:"password"`
-	Age			int	   	`json:"age"`
-	Gender		string 	`json:"gender"`
-	HighScore	float64	`json:"high_score"`
-	CreatedAt	int64	`json:"created_at"`
-}
\ No newline at end of file
+	UID			string  `json:"uid" validate:"readonly,required"`
+	Email		string 	`json:"email" validate:"required,email,max=254"`
+	Username	string 	`json:"username" validate:"min=3,max=30,username"`
+	Password	string 	`json:"password" validate:"min=8,max=128"`
+	Age			int	   	`json:"age" validate:"min=13,max=120"`
+	Gender		string 	`json:"gender" validate:"oneof=Male Female"`
+	HighScore	float64	`json:"high_score" validate:"readonly"`
+	CreatedAt	int64	`json:"created_at" validate:"readonly"`
+	Plan		string	`json:"plan" validate:"readonly"`
+}
+
+// RoleAssignment asks for a user's roles to be replaced.
+type RoleAssignment struct {
+	UID        string   `json:"uid" validate:"required"`
+	Roles      []string `json:"roles" validate:"oneof=admin moderator"`
+	AssignedBy string   `json:"assigned_by" validate:"readonly,required"`
+}
@@ -44,6 +44,10 @@ async function run() {
       const messageValue = message.value.toString();
       console.log(`Received message: ${messageValue}`);
 
+      // Echo the gateway's correlation ID so it can match our response to
+      // the request that is waiting for it.
+      const correlationId = message.headers?.correlationID;
+
       let parsedMessage;
       try {
         parsedMessage = JSON.parse(messageValue);
@@ -57,7 +61,9 @@ async function run() {
       });
 
       const imageUrl = parsedMessage.image_url;
+      const imageKey = parsedMessage.image_key;
       const userId = parsedMessage.user_id;
+      const jobId = parsedMessage.job_id;
       if (!imageUrl || !userId) {
         console.error("Missing imageUrl or userId in the message.");
         return;
@@ -355,10 +361,12 @@ async function run() {
           });
 
           const responseText = completion.choices[0].message.content;
-          let jsonResponse = parseResponse(responseText);
+          jsonResponse = parseResponse(responseText);
           jsonResponse.user_id = userId;
-          jsonResponse.statuscode = 200;
+          jsonResponse.job_id = jobId;
+          jsonResponse.statusCode = 200;
           jsonResponse.image_url = imageUrl;
+          jsonResponse.image_key = imageKey;
 
           // Include userID in the JSON response
 
@@ -376,12 +384,17 @@ async function run() {
         }
         attempt++;
       }
-      if (jsonResponse.total_score === 0) {
+      if (!jsonResponse || !(jsonResponse.total_score > 0)) {
+        // Failures use the same {code, message} error as the Go services.
+        const message = "Failed to generate a valid score after 3 attempts";
         jsonResponse = {
           user_id: userId,
-          statuscode: 400,
-          error: "Failed to generate a valid score after 3 attempts",
+          job_id: jobId,
+          statusCode: 503,
+          message,
+          error: { code: "unavailable", message },
           image_url: imageUrl,
+          image_key: imageKey,
         };
       }
 
@@ -391,6 +404,7 @@ async function run() {
           {
             key: userId,
             value: JSON.stringify(jsonResponse),
+            headers: correlationId ? { correlationID: correlationId } : {},
           },
         ],
       });
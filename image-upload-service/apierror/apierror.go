@@ -0,0 +1,120 @@
+// Package apierror is the error model shared by the gateway and the services.
+// The same file is kept in every service that answers the gateway. Services
+// put an Error in the "error" field of their Kafka replies, and the gateway
+// answers clients with {"error": Error}, choosing the HTTP status from the
+// code.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error codes. They are stable, so clients can match on them.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthenticated  = "unauthenticated"
+	CodePermissionDenied = "permission_denied"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeExpired          = "expired"
+	CodePayloadTooLarge  = "payload_too_large"
+	CodeValidationFailed = "validation_failed"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+	CodeTimeout          = "timeout"
+)
+
+var statuses = map[string]int{
+	CodeInvalidRequest:   http.StatusBadRequest,
+	CodeUnauthenticated:  http.StatusUnauthorized,
+	CodePermissionDenied: http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeConflict:         http.StatusConflict,
+	CodeExpired:          http.StatusGone,
+	CodePayloadTooLarge:  http.StatusRequestEntityTooLarge,
+	CodeValidationFailed: http.StatusUnprocessableEntity,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeInternal:         http.StatusInternalServerError,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+	CodeTimeout:          http.StatusGatewayTimeout,
+}
+
+// Error is a failure as clients see it.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details holds more about the failure, such as the fields that failed
+	// validation.
+	Details interface{} `json:"details,omitempty"`
+	// RequestID is filled in by the gateway.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func New(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithDetails returns a copy of e with details.
+func (e *Error) WithDetails(details interface{}) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// Status is the HTTP status for e's code. Unknown codes are internal errors.
+func (e *Error) Status() int {
+	if status, exists := statuses[e.Code]; exists {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// FromStatus makes an Error for an HTTP status, for failures that only carry a
+// status code.
+func FromStatus(status int, message string) *Error {
+	code := CodeInternal
+	for candidate, candidateStatus := range statuses {
+		if candidateStatus == status {
+			code = candidate
+			break
+		}
+	}
+	if code == CodeInternal && status >= 400 && status < 500 {
+		code = CodeInvalidRequest
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return New(code, message)
+}
+
+// As returns the Error in err's chain, if there is one.
+func As(err error) (*Error, bool) {
+	var apiErr *Error
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+// SetReply fills in a Kafka reply's statusCode and message, and its error
+// when statusCode is a failure.
+func SetReply(reply map[string]interface{}, statusCode int, message string) {
+	if statusCode >= 400 {
+		SetError(reply, FromStatus(statusCode, message))
+		return
+	}
+	reply["statusCode"] = statusCode
+	reply["message"] = message
+}
+
+// SetError marks a Kafka reply as failed with err.
+func SetError(reply map[string]interface{}, err *Error) {
+	reply["statusCode"] = err.Status()
+	reply["message"] = err.Message
+	reply["error"] = err
+}
@@ -0,0 +1,69 @@
+// Command backfill-image-status gives the images scored before image
+// documents had a lifecycle the fields newer ones have: Status scored, and
+// CreatedAt, UpdatedAt and Timestamps from when the document was written.
+// GET /api/images lists images by CreatedAt, so it does not show them until
+// this has run.
+//
+// It is safe to run more than once. Run it from the image-upload-service
+// directory with the service's environment:
+//
+//	go run ./cmd/backfill-image-status [-dry-run]
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"image-upload-service/models"
+	"image-upload-service/utils"
+
+	"cloud.google.com/go/firestore"
+	"github.com/joho/godotenv"
+	"google.golang.org/api/iterator"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would change without changing it")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+
+	utils.InitFirebase()
+	defer utils.CloseFirestore()
+
+	ctx := context.Background()
+	backfilled := 0
+	docs := utils.FirestoreClient.Collection("images").Documents(ctx)
+	defer docs.Stop()
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error reading images: %v", err)
+		}
+		if _, hasStatus := doc.Data()["Status"]; hasStatus {
+			continue
+		}
+		if *dryRun {
+			log.Printf("Would mark images/%s scored at %v", doc.Ref.ID, doc.CreateTime)
+			continue
+		}
+		created, updated := doc.CreateTime.Unix(), doc.UpdateTime.Unix()
+		_, err = doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "Status", Value: models.ImageScored},
+			{Path: "Timestamps", Value: map[string]int64{string(models.ImageScored): created}},
+			{Path: "CreatedAt", Value: created},
+			{Path: "UpdatedAt", Value: updated},
+		})
+		if err != nil {
+			log.Fatalf("Error backfilling images/%s: %v", doc.Ref.ID, err)
+		}
+		backfilled++
+	}
+	log.Printf("Backfilled %d images", backfilled)
+}
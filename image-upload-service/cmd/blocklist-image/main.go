@@ -0,0 +1,101 @@
+// Command blocklist-image manages the image-blocklist collection, the images
+// operators know must not be scored, such as celebrity or stock photos.
+// Uploads that look like a blocklisted image are flagged for review. Only the
+// perceptual hashes of a blocklisted image are kept, never the image itself.
+//
+// Run it from the image-upload-service directory with the service's
+// environment:
+//
+//	go run ./cmd/blocklist-image -label "Stock photo" photo.jpg...
+//	go run ./cmd/blocklist-image -label "Reported" -image IMAGE_ID
+//	go run ./cmd/blocklist-image -remove BLOCKLIST_ID
+//
+// Files are normalized the way uploads are before they are hashed. -image
+// blocklists an image that was already scored, by its document ID.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/user"
+
+	"image-upload-service/controllers"
+	"image-upload-service/imaging"
+	"image-upload-service/models"
+	"image-upload-service/utils"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	label := flag.String("label", "", "why the image is blocked, shown to moderators")
+	imageID := flag.String("image", "", "blocklist a scored image by its ID instead of files")
+	remove := flag.String("remove", "", "remove a blocklist entry by its ID")
+	addedBy := flag.String("added-by", currentUser(), "who is adding the images")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+	utils.InitFirebase()
+	defer utils.CloseFirestore()
+	ctx := context.Background()
+
+	if *remove != "" {
+		if _, err := utils.FirestoreClient.Collection("image-blocklist").Doc(*remove).Delete(ctx); err != nil {
+			log.Fatalf("Error removing %s: %v", *remove, err)
+		}
+		log.Printf("Removed %s", *remove)
+		return
+	}
+
+	if *label == "" || (*imageID == "") == (flag.NArg() == 0) {
+		log.Fatal("Give -label and either -image or one or more files")
+	}
+
+	if *imageID != "" {
+		doc, err := utils.FirestoreClient.Collection("images").Doc(*imageID).Get(ctx)
+		if err != nil {
+			log.Fatalf("Error getting image %s: %v", *imageID, err)
+		}
+		var image struct{ Fingerprint *models.Fingerprint }
+		if err := doc.DataTo(&image); err != nil {
+			log.Fatalf("Error reading image %s: %v", *imageID, err)
+		}
+		if image.Fingerprint == nil {
+			log.Fatalf("Image %s has no fingerprint; blocklist its file instead", *imageID)
+		}
+		add(*label, *addedBy, *imageID, *image.Fingerprint)
+		return
+	}
+
+	options := imaging.OptionsFromEnv()
+	for _, path := range flag.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		normalized, err := imaging.Normalize(data, options)
+		if err != nil {
+			log.Fatalf("Error normalizing %s: %v", path, err)
+		}
+		add(*label, *addedBy, path, controllers.Fingerprint(normalized.Image))
+	}
+}
+
+func add(label, addedBy, source string, fingerprint models.Fingerprint) {
+	id, err := controllers.AddBlockedImage(label, addedBy, fingerprint)
+	if err != nil {
+		log.Fatalf("Error blocklisting %s: %v", source, err)
+	}
+	log.Printf("Blocklisted %s as %s (phash %s, dhash %s)", source, id, fingerprint.PHash, fingerprint.DHash)
+}
+
+func currentUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
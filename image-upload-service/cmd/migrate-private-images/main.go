@@ -0,0 +1,171 @@
+// Command migrate-private-images makes stored face photos private. Images used
+// to be uploaded with a public-read ACL and recorded by their public URL; now
+// they are private and recorded by object key, and are shown through signed
+// URLs. The command
+//
+//   - removes allUsers and allAuthenticatedUsers from the bucket's IAM policy,
+//   - removes the public-read ACL from every object under images/ (skipped when
+//     the bucket uses uniform bucket-level access, which has no object ACLs),
+//   - replaces ImageURL with ImageKey in the images and jobs collections.
+//
+// It is safe to run more than once. Run it from the image-upload-service
+// directory with the service's environment, after deploying the services that
+// read ImageKey:
+//
+//	go run ./cmd/migrate-private-images [-dry-run]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"image-upload-service/utils"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/joho/godotenv"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+var publicMembers = []string{string(storage.AllUsers), string(storage.AllAuthenticatedUsers)}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would change without changing it")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+	bucketName := os.Getenv("BUCKET_NAME")
+	if bucketName == "" {
+		log.Fatal("BUCKET_NAME is not set")
+	}
+
+	utils.InitFirebase()
+	defer utils.CloseFirestore()
+
+	ctx := context.Background()
+	bucket := utils.StorageClient.Bucket(bucketName)
+
+	if err := revokeBucketAccess(ctx, bucket, *dryRun); err != nil {
+		log.Fatalf("Error revoking public bucket access: %v", err)
+	}
+	if err := revokeObjectACLs(ctx, bucket, *dryRun); err != nil {
+		log.Fatalf("Error revoking public object ACLs: %v", err)
+	}
+	publicPrefix := "https://storage.googleapis.com/" + bucketName + "/"
+	for _, collection := range []string{"images", "jobs"} {
+		if err := migrateImageURLs(ctx, collection, publicPrefix, *dryRun); err != nil {
+			log.Fatalf("Error migrating %s: %v", collection, err)
+		}
+	}
+	log.Println("Done")
+}
+
+// revokeBucketAccess removes public members from every role on the bucket.
+func revokeBucketAccess(ctx context.Context, bucket *storage.BucketHandle, dryRun bool) error {
+	policy, err := bucket.IAM().Policy(ctx)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, role := range policy.Roles() {
+		for _, member := range publicMembers {
+			if policy.HasRole(member, role) {
+				log.Printf("Removing %s from %s on the bucket", member, role)
+				policy.Remove(member, role)
+				changed = true
+			}
+		}
+	}
+	if !changed || dryRun {
+		return nil
+	}
+	return bucket.IAM().SetPolicy(ctx, policy)
+}
+
+// revokeObjectACLs removes public ACL entries from every stored image.
+func revokeObjectACLs(ctx context.Context, bucket *storage.BucketHandle, dryRun bool) error {
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return err
+	}
+	if attrs.UniformBucketLevelAccess.Enabled {
+		log.Println("Bucket uses uniform bucket-level access, skipping object ACLs")
+		return nil
+	}
+
+	revoked := 0
+	objects := bucket.Objects(ctx, &storage.Query{Prefix: "images/"})
+	for {
+		object, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, rule := range object.ACL {
+			if rule.Entity != storage.AllUsers && rule.Entity != storage.AllAuthenticatedUsers {
+				continue
+			}
+			log.Printf("Removing %s from %s", rule.Entity, object.Name)
+			if dryRun {
+				continue
+			}
+			if err := bucket.Object(object.Name).ACL().Delete(ctx, rule.Entity); err != nil && !isNotFound(err) {
+				return err
+			}
+			revoked++
+		}
+	}
+	log.Printf("Removed %d public object ACL entries", revoked)
+	return nil
+}
+
+// migrateImageURLs moves documents in collection from a public ImageURL to
+// the object's ImageKey.
+func migrateImageURLs(ctx context.Context, collection, publicPrefix string, dryRun bool) error {
+	migrated := 0
+	docs := utils.FirestoreClient.Collection(collection).Where("ImageURL", "!=", "").Documents(ctx)
+	defer docs.Stop()
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		url, _ := doc.Data()["ImageURL"].(string)
+		key, isPublic := strings.CutPrefix(url, publicPrefix)
+		if !isPublic {
+			log.Printf("Skipping %s/%s: %q is not in this bucket", collection, doc.Ref.ID, url)
+			continue
+		}
+		if dryRun {
+			log.Printf("Would set %s/%s ImageKey to %s", collection, doc.Ref.ID, key)
+			continue
+		}
+		_, err = doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "ImageKey", Value: key},
+			{Path: "ImageURL", Value: firestore.Delete},
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	log.Printf("Migrated %d %s documents", migrated, collection)
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.Is(err, storage.ErrObjectNotExist) || (errors.As(err, &apiErr) && apiErr.Code == 404)
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"image"
+	"image-upload-service/imaging"
+	"image-upload-service/models"
+	"image-upload-service/utils"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// BlockedImage is an entry in the image-blocklist collection: an image
+// operators know must not be scored, such as a celebrity or stock photo.
+type BlockedImage struct {
+	Label       string             `json:"label"`
+	Fingerprint models.Fingerprint `json:"fingerprint"`
+	AddedBy     string             `json:"added_by"`
+	CreatedAt   int64              `json:"created_at"`
+}
+
+// maxCandidates bounds how many images sharing a hash band are compared with
+// an upload, per collection.
+const maxCandidates = 500
+
+// MatchDistanceFromEnv reads IMAGE_MATCH_DISTANCE, the largest Hamming
+// distance at which two images count as the same (default 6). Matches are
+// found through hash bands, which only guarantees finding them up to 7 bits.
+func MatchDistanceFromEnv() int {
+	value := os.Getenv("IMAGE_MATCH_DISTANCE")
+	if value == "" {
+		return 6
+	}
+	distance, err := strconv.Atoi(value)
+	if err != nil || distance < 0 || distance > 7 {
+		log.Printf("Invalid IMAGE_MATCH_DISTANCE %q, using 6", value)
+		return 6
+	}
+	return distance
+}
+
+// Fingerprint hashes a normalized image.
+func Fingerprint(img image.Image) models.Fingerprint {
+	phash, dhash := imaging.PHash(img), imaging.DHash(img)
+	return models.Fingerprint{
+		PHash: imaging.FormatHash(phash),
+		DHash: imaging.FormatHash(dhash),
+		Bands: append(imaging.HashBands("p", phash), imaging.HashBands("d", dhash)...),
+	}
+}
+
+// FindMatches returns the other users' images and the blocklist entries that
+// are within maxDistance of fingerprint by either hash. The user's own images
+// are left out: re-uploading them is covered by the content hash.
+func FindMatches(userID string, fingerprint models.Fingerprint, maxDistance int) ([]models.FlagMatch, error) {
+	ctx := context.Background()
+	matches := []models.FlagMatch{}
+
+	images := utils.FirestoreClient.Collection("images").
+		Where("Fingerprint.Bands", "array-contains-any", fingerprint.Bands).
+		Limit(maxCandidates).Documents(ctx)
+	err := eachCandidate(images, func(doc *firestore.DocumentSnapshot) error {
+		var candidate struct {
+			UserId      string
+			Fingerprint models.Fingerprint
+		}
+		if err := doc.DataTo(&candidate); err != nil {
+			return err
+		}
+		if candidate.UserId == userID {
+			return nil
+		}
+		if distance, ok := within(fingerprint, candidate.Fingerprint, maxDistance); ok {
+			matches = append(matches, models.FlagMatch{
+				Kind:     models.FlagNearDuplicate,
+				ImageId:  doc.Ref.ID,
+				UserId:   candidate.UserId,
+				Distance: distance,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := utils.FirestoreClient.Collection("image-blocklist").
+		Where("Fingerprint.Bands", "array-contains-any", fingerprint.Bands).
+		Limit(maxCandidates).Documents(ctx)
+	err = eachCandidate(blocked, func(doc *firestore.DocumentSnapshot) error {
+		var entry BlockedImage
+		if err := doc.DataTo(&entry); err != nil {
+			return err
+		}
+		if distance, ok := within(fingerprint, entry.Fingerprint, maxDistance); ok {
+			matches = append(matches, models.FlagMatch{
+				Kind:        models.FlagBlocklisted,
+				BlocklistId: doc.Ref.ID,
+				Label:       entry.Label,
+				Distance:    distance,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// AddBlockedImage adds an image to the blocklist and returns its ID.
+func AddBlockedImage(label, addedBy string, fingerprint models.Fingerprint) (string, error) {
+	doc, _, err := utils.FirestoreClient.Collection("image-blocklist").Add(context.Background(), BlockedImage{
+		Label:       label,
+		Fingerprint: fingerprint,
+		AddedBy:     addedBy,
+		CreatedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return doc.ID, nil
+}
+
+func eachCandidate(iter *firestore.DocumentIterator, match func(*firestore.DocumentSnapshot) error) error {
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := match(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// within compares two fingerprints, returning the smaller distance of their
+// hashes and whether it is at most maxDistance.
+func within(a, b models.Fingerprint, maxDistance int) (int, bool) {
+	distance := -1
+	for _, pair := range [][2]string{{a.PHash, b.PHash}, {a.DHash, b.DHash}} {
+		x, err := imaging.ParseHash(pair[0])
+		if err != nil {
+			continue
+		}
+		y, err := imaging.ParseHash(pair[1])
+		if err != nil {
+			continue
+		}
+		if d := imaging.Hamming(x, y); distance < 0 || d < distance {
+			distance = d
+		}
+	}
+	return distance, distance >= 0 && distance <= maxDistance
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image-upload-service/utils"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ImageHash is an entry in the image-hashes collection, the per-user index of
+// scored images by content hash. Its document ID is the user ID and the hash.
+type ImageHash struct {
+	UserId      string `json:"user_id"`
+	ContentHash string `json:"content_hash"`
+	ImageId     string `json:"image_id"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// ContentHash is the hex SHA-256 of a normalized image. Normalizing the same
+// upload always gives the same bytes, so it identifies repeat uploads.
+func ContentHash(normalized []byte) string {
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:])
+}
+
+func imageHashRef(userID, hash string) *firestore.DocumentRef {
+	return utils.FirestoreClient.Collection("image-hashes").Doc(userID + "_" + hash)
+}
+
+// RecordImageHash adds a scored image to the user's hash index.
+func RecordImageHash(userID, hash, imageID string) error {
+	_, err := imageHashRef(userID, hash).Set(context.Background(), ImageHash{
+		UserId:      userID,
+		ContentHash: hash,
+		ImageId:     imageID,
+		CreatedAt:   time.Now().Unix(),
+	})
+	return err
+}
+
+// FindScoredImage returns the image document the user already had scored
+// for hash, or nil. Index entries for deleted images are dropped.
+func FindScoredImage(userID, hash string) (*firestore.DocumentSnapshot, error) {
+	ctx := context.Background()
+	entryDoc, err := imageHashRef(userID, hash).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry ImageHash
+	if err := entryDoc.DataTo(&entry); err != nil {
+		return nil, err
+	}
+
+	imageDoc, err := utils.FirestoreClient.Collection("images").Doc(entry.ImageId).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		_, err := entryDoc.Ref.Delete(ctx)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return imageDoc, nil
+}
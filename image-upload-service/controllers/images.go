@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"image-upload-service/imaging"
+	"image-upload-service/models"
+
+	"cloud.google.com/go/storage"
+)
+
+// StoreImage writes a normalized image to images/<folder>/original.jpg and
+// each rendition next to it, and returns the original's key and the
+// renditions. The objects are private.
+func StoreImage(ctx context.Context, bucket *storage.BucketHandle, folder string, normalized *imaging.Normalized) (string, map[string]models.Rendition, error) {
+	prefix := "images/" + folder + "/"
+	key := prefix + "original.jpg"
+	if err := writeJPEG(ctx, bucket.Object(key), normalized.Original.Data); err != nil {
+		return "", nil, err
+	}
+
+	renditions := make(map[string]models.Rendition, len(normalized.Renditions))
+	for name, encoded := range normalized.Renditions {
+		renditionKey := fmt.Sprintf("%s%s.jpg", prefix, name)
+		if err := writeJPEG(ctx, bucket.Object(renditionKey), encoded.Data); err != nil {
+			return "", nil, err
+		}
+		renditions[name] = models.Rendition{Key: renditionKey, Width: encoded.Width, Height: encoded.Height}
+	}
+	return key, renditions, nil
+}
+
+func writeJPEG(ctx context.Context, object *storage.ObjectHandle, data []byte) error {
+	writer := object.NewWriter(ctx)
+	writer.ContentType = "image/jpeg"
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image-upload-service/models"
+	"image-upload-service/utils"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/IBM/sarama"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrJobEnded is reported when a job that is already scored or failed would
+// be moved again.
+var ErrJobEnded = errors.New("job has already ended")
+
+// JobEvent is published on the job-status topic, keyed by user ID, each time
+// a scan job changes status. The gateway pushes it to the user's open streams.
+type JobEvent struct {
+	JobId   string           `json:"job_id"`
+	Status  models.JobStatus `json:"status"`
+	ImageId string           `json:"image_id,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	// ErrorCode is set when the image was turned down, see RejectJob.
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// UpdateJobStatus moves a scan job to status, applying any extra field updates
+// alongside it. Jobs that have already ended are left as they are, so a late
+// or repeated message cannot reopen them. Jobs are created by the gateway;
+// uploads without a job ID are not tracked.
+func UpdateJobStatus(jobID, userID string, status models.JobStatus, updates ...firestore.Update) {
+	moveJob(jobID, userID, status, false, time.Time{}, updates)
+}
+
+// moveJob moves a job that has not ended to status and reports whether it
+// did. With refund set, the scan charged for the job is given back in the
+// same transaction, see refundFailedJob.
+func moveJob(jobID, userID string, status models.JobStatus, refund bool, chargedAt time.Time, updates []firestore.Update) bool {
+	if jobID == "" {
+		return false
+	}
+
+	event := JobEvent{JobId: jobID, Status: status}
+	for _, update := range updates {
+		switch update.Path {
+		case "ImageId":
+			event.ImageId, _ = update.Value.(string)
+		case "Error":
+			event.Error, _ = update.Value.(string)
+		case "ErrorCode":
+			event.ErrorCode, _ = update.Value.(string)
+		}
+	}
+
+	now := time.Now()
+	updates = append(updates,
+		firestore.Update{Path: "Status", Value: status},
+		firestore.Update{Path: "UpdatedAt", Value: now.Unix()},
+	)
+	ref := utils.FirestoreClient.Collection("jobs").Doc(jobID)
+	err := utils.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var job models.Job
+		if err := doc.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status.Final() {
+			return fmt.Errorf("%w: %s is %s", ErrJobEnded, jobID, job.Status)
+		}
+		if !refund {
+			return tx.Update(ref, updates)
+		}
+
+		// All reads come before the writes.
+		quotaDoc := quotaRef(userID)
+		quota, err := readQuota(tx, quotaDoc)
+		if err != nil {
+			return err
+		}
+		refunded, err := refundFailedJob(job, &quota, chargedAt, now)
+		if err != nil {
+			return err
+		}
+		if refunded {
+			if err := tx.Set(quotaDoc, quota); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		log.Printf("Error updating job %s to %s: %v", jobID, status, err)
+		return false
+	}
+
+	publishJobEvent(userID, event)
+	return true
+}
+
+// refundFailedJob takes the scan charged for a job that is about to fail off
+// quota, and reports whether it did. The scan was charged at chargedAt or,
+// when that is zero, at the job's QuotaChargedAt; jobs with neither were not
+// charged. A job that has already ended returns ErrJobEnded and refunds
+// nothing, so a repeated or late failure cannot give the scan back twice.
+func refundFailedJob(job models.Job, quota *Quota, chargedAt, now time.Time) (bool, error) {
+	if job.Status.Final() {
+		return false, fmt.Errorf("%w: job is %s", ErrJobEnded, job.Status)
+	}
+	if chargedAt.IsZero() && job.QuotaChargedAt != 0 {
+		chargedAt = time.Unix(job.QuotaChargedAt, 0)
+	}
+	if chargedAt.IsZero() {
+		return false, nil
+	}
+	quota.refund(chargedAt, now)
+	return true, nil
+}
+
+// GetJob returns nil without an error when the job does not exist.
+func GetJob(jobID string) (*models.Job, error) {
+	if jobID == "" {
+		return nil, nil
+	}
+	doc, err := utils.FirestoreClient.Collection("jobs").Doc(jobID).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job models.Job
+	if err := doc.DataTo(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FailJob marks a scan job and its image as failed with a reason the caller
+// can show, applying any extra field updates to both.
+func FailJob(jobID, userID, reason string, updates ...firestore.Update) {
+	updates = append(updates, firestore.Update{Path: "Error", Value: reason})
+	UpdateJobStatus(jobID, userID, models.JobFailed, updates...)
+	endImage(jobID, models.ImageFailed, updates)
+}
+
+// FailChargedJob fails a scan job like FailJob and gives back the scan it was
+// charged, charged at chargedAt or, when that is zero, at the job's
+// QuotaChargedAt. The refund is made in the transaction that fails the job,
+// so only the failure that ends the job refunds it.
+func FailChargedJob(jobID, userID, reason string, chargedAt time.Time) {
+	updates := []firestore.Update{{Path: "Error", Value: reason}}
+	if jobID == "" {
+		// Untracked uploads have nothing to fail; the scan is still given back.
+		if !chargedAt.IsZero() {
+			if err := RefundScan(userID, chargedAt); err != nil {
+				log.Printf("Error refunding scan of user %s: %v", userID, err)
+			}
+		}
+		return
+	}
+	moveJob(jobID, userID, models.JobFailed, true, chargedAt, updates)
+	endImage(jobID, models.ImageFailed, updates)
+}
+
+// RejectJob fails a scan job because its image is not acceptable, with a
+// code such as no_face that clients can act on next to the reason, applying
+// any extra field updates alongside. The image is marked rejected.
+func RejectJob(jobID, userID, code, reason string, updates ...firestore.Update) {
+	updates = append(updates,
+		firestore.Update{Path: "Error", Value: reason},
+		firestore.Update{Path: "ErrorCode", Value: code},
+	)
+	UpdateJobStatus(jobID, userID, models.JobFailed, updates...)
+	endImage(jobID, models.ImageRejected, updates)
+}
+
+// endImage moves the image of a job that did not get scored, which shares
+// the job's ID.
+func endImage(imageID string, next models.ImageStatus, updates []firestore.Update) {
+	if err := MoveImage(imageID, next, updates...); err != nil {
+		log.Printf("Error moving image %s to %s: %v", imageID, next, err)
+	}
+}
+
+func publishJobEvent(userID string, event JobEvent) {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshalling job event: %v", err)
+		return
+	}
+
+	err = utils.KafkaProducer.SendMessage(&sarama.ProducerMessage{
+		Topic: "job-status",
+		Key:   sarama.StringEncoder(userID),
+		Value: sarama.ByteEncoder(jsonData),
+	})
+	if err != nil {
+		log.Printf("Error producing job event: %v", err)
+	}
+}
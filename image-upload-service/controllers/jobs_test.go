@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"errors"
+	"image-upload-service/models"
+	"testing"
+	"time"
+)
+
+func TestRefundFailedJobOnce(t *testing.T) {
+	chargedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	now := chargedAt.Add(time.Minute)
+	charged := func() Quota {
+		return Quota{Counters: map[string]QuotaCounter{
+			WindowDaily:   {Period: "2026-10-18", Count: 2},
+			WindowWeekly:  {Period: "2026-W42", Count: 2},
+			WindowMonthly: {Period: "2026-10", Count: 2},
+		}}
+	}
+
+	// The first failure reply for a job being scored gives its scan back.
+	job := models.Job{Status: models.JobScoring, QuotaChargedAt: chargedAt.Unix()}
+	quota := charged()
+	refunded, err := refundFailedJob(job, &quota, time.Time{}, now)
+	if err != nil || !refunded {
+		t.Fatalf("refundFailedJob(scoring) = %v, %v, want a refund", refunded, err)
+	}
+	for window, counter := range quota.Counters {
+		if counter.Count != 1 {
+			t.Errorf("%s count = %d after the refund, want 1", window, counter.Count)
+		}
+	}
+
+	// The same reply delivered again finds the job failed.
+	job.Status = models.JobFailed
+	refunded, err = refundFailedJob(job, &quota, time.Time{}, now)
+	if !errors.Is(err, ErrJobEnded) || refunded {
+		t.Fatalf("refundFailedJob(failed) = %v, %v, want ErrJobEnded", refunded, err)
+	}
+	for window, counter := range quota.Counters {
+		if counter.Count != 1 {
+			t.Errorf("%s count = %d after a repeated reply, want 1", window, counter.Count)
+		}
+	}
+}
+
+func TestRefundFailedJobLeavesEndedJobs(t *testing.T) {
+	chargedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	for _, status := range []models.JobStatus{models.JobScored, models.JobFailed} {
+		quota := Quota{Counters: map[string]QuotaCounter{WindowDaily: {Period: "2026-10-18", Count: 1}}}
+		job := models.Job{Status: status, QuotaChargedAt: chargedAt.Unix()}
+		refunded, err := refundFailedJob(job, &quota, time.Time{}, chargedAt.Add(time.Hour))
+		if !errors.Is(err, ErrJobEnded) || refunded {
+			t.Errorf("refundFailedJob(%s) = %v, %v, want ErrJobEnded", status, refunded, err)
+		}
+		if quota.Counters[WindowDaily].Count != 1 {
+			t.Errorf("refundFailedJob(%s) changed the count to %d", status, quota.Counters[WindowDaily].Count)
+		}
+	}
+}
+
+func TestRefundFailedJobChargeTime(t *testing.T) {
+	chargedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	now := chargedAt.Add(time.Minute)
+
+	// A job that fails before it is stored has no QuotaChargedAt yet; the
+	// caller passes the charge time.
+	quota := Quota{Counters: map[string]QuotaCounter{WindowDaily: {Period: "2026-10-18", Count: 1}}}
+	refunded, err := refundFailedJob(models.Job{Status: models.JobQueued}, &quota, chargedAt, now)
+	if err != nil || !refunded || quota.Counters[WindowDaily].Count != 0 {
+		t.Errorf("refundFailedJob(chargedAt) = %v, %v, count %d, want a refund", refunded, err, quota.Counters[WindowDaily].Count)
+	}
+
+	// A job that was never charged has nothing to give back.
+	quota = Quota{Counters: map[string]QuotaCounter{WindowDaily: {Period: "2026-10-18", Count: 1}}}
+	refunded, err = refundFailedJob(models.Job{Status: models.JobScoring}, &quota, time.Time{}, now)
+	if err != nil || refunded || quota.Counters[WindowDaily].Count != 1 {
+		t.Errorf("refundFailedJob(uncharged) = %v, %v, count %d, want no refund", refunded, err, quota.Counters[WindowDaily].Count)
+	}
+}
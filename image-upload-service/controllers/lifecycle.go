@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image-upload-service/models"
+	"image-upload-service/utils"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// An image document is created as soon as its upload is picked up, so failed
+// and unfinished uploads are kept too. It shares its ID with the upload's
+// scan job. Statuses are recorded in Status, with the time each was reached
+// in Timestamps.
+
+var (
+	// ErrImageNotFound is returned when moving an image whose document does
+	// not exist, such as that of a job started before images were tracked
+	// from upload.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageTransition is returned when an image's status does not allow
+	// the move, as when a message is delivered again.
+	ErrImageTransition = errors.New("image status does not allow the move")
+)
+
+// CreateImage records an uploaded image and returns its status. Creating it
+// again, for a message delivered twice, leaves the existing document as it is
+// and returns the status it has reached.
+func CreateImage(imageID, userID, filename string) (models.ImageStatus, error) {
+	if imageID == "" {
+		return models.ImageUploaded, nil
+	}
+	ref := utils.FirestoreClient.Collection("images").Doc(imageID)
+	now := time.Now().Unix()
+	_, err := ref.Create(context.Background(), map[string]interface{}{
+		"UserId":     userID,
+		"JobId":      imageID,
+		"Filename":   filename,
+		"Status":     models.ImageUploaded,
+		"Timestamps": map[string]int64{string(models.ImageUploaded): now},
+		"CreatedAt":  now,
+		"UpdatedAt":  now,
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		return models.ImageUploaded, err
+	}
+
+	doc, err := ref.Get(context.Background())
+	if err != nil {
+		return "", err
+	}
+	var image struct {
+		Status models.ImageStatus
+	}
+	if err := doc.DataTo(&image); err != nil {
+		return "", err
+	}
+	return image.Status, nil
+}
+
+// MoveImage moves an image to next, applying any extra field updates
+// alongside it. It returns ErrImageNotFound or ErrImageTransition, wrapped,
+// when the image cannot move. Uploads without a job have no image document
+// until they are scored, and are not moved.
+func MoveImage(imageID string, next models.ImageStatus, updates ...firestore.Update) error {
+	if imageID == "" {
+		return nil
+	}
+	ref := utils.FirestoreClient.Collection("images").Doc(imageID)
+	return utils.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s", ErrImageNotFound, imageID)
+		}
+		if err != nil {
+			return err
+		}
+		var image struct {
+			Status models.ImageStatus
+		}
+		if err := doc.DataTo(&image); err != nil {
+			return err
+		}
+		if !image.Status.CanMoveTo(next) {
+			return fmt.Errorf("%w: %s from %s to %s", ErrImageTransition, imageID, image.Status, next)
+		}
+
+		now := time.Now().Unix()
+		return tx.Update(ref, append(updates,
+			firestore.Update{Path: "Status", Value: next},
+			firestore.Update{FieldPath: firestore.FieldPath{"Timestamps", string(next)}, Value: now},
+			firestore.Update{Path: "UpdatedAt", Value: now},
+		))
+	})
+}
+
+// UpdateImage sets fields of an image without moving it.
+func UpdateImage(imageID string, updates ...firestore.Update) error {
+	if imageID == "" {
+		return nil
+	}
+	updates = append(updates, firestore.Update{Path: "UpdatedAt", Value: time.Now().Unix()})
+	_, err := utils.FirestoreClient.Collection("images").Doc(imageID).Update(context.Background(), updates)
+	return err
+}
+
+// DiscardImage removes the document of an upload that turned out to repeat
+// an image the user already had scored; its job points at that image.
+func DiscardImage(imageID string) error {
+	if imageID == "" {
+		return nil
+	}
+	_, err := utils.FirestoreClient.Collection("images").Doc(imageID).Delete(context.Background())
+	return err
+}
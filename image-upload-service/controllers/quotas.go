@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image-upload-service/utils"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Plans a user can be on. Users without a plan are on the free one.
+const (
+	PlanFree = "free"
+	PlanPlus = "plus"
+	PlanPro  = "pro"
+)
+
+// Quota windows. Each is counted in UTC and resets at the start of the next
+// day, ISO week or month.
+const (
+	WindowDaily   = "daily"
+	WindowWeekly  = "weekly"
+	WindowMonthly = "monthly"
+)
+
+// QuotaWindows are checked shortest first, so a user over several limits is
+// told about the one that resets soonest.
+var QuotaWindows = []string{WindowDaily, WindowWeekly, WindowMonthly}
+
+// Limits are the scans a plan allows in each window; 0 means no limit.
+type Limits map[string]int
+
+// Plans maps each plan to its limits.
+type Plans map[string]Limits
+
+// For returns the limits of plan, or of the free plan when plan is unknown.
+func (p Plans) For(plan string) Limits {
+	if limits, ok := p[plan]; ok {
+		return limits
+	}
+	return p[PlanFree]
+}
+
+// PlansFromEnv reads the daily, weekly and monthly limits of each plan as
+// "daily,weekly,monthly" from QUOTA_FREE (default 3,10,30), QUOTA_PLUS
+// (10,50,150) and QUOTA_PRO (50,250,1000).
+func PlansFromEnv() Plans {
+	return Plans{
+		PlanFree: envLimits("QUOTA_FREE", Limits{WindowDaily: 3, WindowWeekly: 10, WindowMonthly: 30}),
+		PlanPlus: envLimits("QUOTA_PLUS", Limits{WindowDaily: 10, WindowWeekly: 50, WindowMonthly: 150}),
+		PlanPro:  envLimits("QUOTA_PRO", Limits{WindowDaily: 50, WindowWeekly: 250, WindowMonthly: 1000}),
+	}
+}
+
+func envLimits(name string, fallback Limits) Limits {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != len(QuotaWindows) {
+		log.Printf("Invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	limits := Limits{}
+	for i, window := range QuotaWindows {
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+		if err != nil || limit < 0 {
+			log.Printf("Invalid %s %q, using %v", name, value, fallback)
+			return fallback
+		}
+		limits[window] = limit
+	}
+	return limits
+}
+
+// QuotaCounter counts a user's scans in one period of a window, such as
+// 2026-10-18 for the daily window.
+type QuotaCounter struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+}
+
+// Quota is an entry in the quotas collection, whose document ID is the user
+// ID. Counters are by window; a counter for an earlier period counts as 0.
+type Quota struct {
+	Counters  map[string]QuotaCounter `json:"counters"`
+	UpdatedAt int64                   `json:"updated_at"`
+}
+
+// QuotaExceededError is returned by ChargeScan when a window has no scans
+// left.
+type QuotaExceededError struct {
+	Window   string
+	Limit    int
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d scans used until %s", e.Window, e.Limit, e.ResetsAt.Format(time.RFC3339))
+}
+
+// QuotaWindowStatus is a window's use, as reported by GET /api/quota. Limit
+// and Remaining are nil when the plan does not limit the window.
+type QuotaWindowStatus struct {
+	Limit     *int  `json:"limit"`
+	Used      int   `json:"used"`
+	Remaining *int  `json:"remaining"`
+	ResetsAt  int64 `json:"resets_at"`
+}
+
+// WindowPeriod names the period of window that t falls in, and returns when
+// the next one starts.
+func WindowPeriod(window string, t time.Time) (string, time.Time) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch window {
+	case WindowDaily:
+		return day.Format("2006-01-02"), day.AddDate(0, 0, 1)
+	case WindowWeekly:
+		year, week := t.ISOWeek()
+		monday := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return fmt.Sprintf("%d-W%02d", year, week), monday.AddDate(0, 0, 7)
+	default:
+		month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return month.Format("2006-01"), month.AddDate(0, 1, 0)
+	}
+}
+
+func quotaRef(userID string) *firestore.DocumentRef {
+	return utils.FirestoreClient.Collection("quotas").Doc(userID)
+}
+
+// UserPlan returns the plan on the user's profile, free when there is none.
+func UserPlan(userID string) (string, error) {
+	doc, err := utils.FirestoreClient.Collection("users").Doc(userID).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return PlanFree, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var user struct {
+		Plan string
+	}
+	if err := doc.DataTo(&user); err != nil {
+		return "", err
+	}
+	if user.Plan == "" {
+		return PlanFree, nil
+	}
+	return user.Plan, nil
+}
+
+// readQuota returns the user's quota within tx, empty when they have not
+// scanned yet.
+func readQuota(tx *firestore.Transaction, ref *firestore.DocumentRef) (Quota, error) {
+	quota := Quota{Counters: map[string]QuotaCounter{}}
+	doc, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return quota, nil
+	}
+	if err != nil {
+		return quota, err
+	}
+	if err := doc.DataTo(&quota); err != nil {
+		return quota, err
+	}
+	if quota.Counters == nil {
+		quota.Counters = map[string]QuotaCounter{}
+	}
+	return quota, nil
+}
+
+// ChargeScan counts a scan at now against every window of the user's plan,
+// or returns a *QuotaExceededError without counting it when a window has no
+// scans left. Concurrent charges are serialized by a transaction.
+func ChargeScan(userID string, plans Plans, now time.Time) error {
+	plan, err := UserPlan(userID)
+	if err != nil {
+		return err
+	}
+	limits := plans.For(plan)
+
+	ref := quotaRef(userID)
+	return utils.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		quota, err := readQuota(tx, ref)
+		if err != nil {
+			return err
+		}
+		if err := quota.charge(limits, now); err != nil {
+			return err
+		}
+		return tx.Set(ref, quota)
+	})
+}
+
+// charge counts a scan at now in every window, starting a window's count
+// over when its period has changed. It changes nothing and returns a
+// *QuotaExceededError when a window is already at its limit.
+func (q *Quota) charge(limits Limits, now time.Time) error {
+	counters := make(map[string]QuotaCounter, len(QuotaWindows))
+	for _, window := range QuotaWindows {
+		period, resetsAt := WindowPeriod(window, now)
+		counter := q.Counters[window]
+		if counter.Period != period {
+			counter = QuotaCounter{Period: period}
+		}
+		if limit := limits[window]; limit > 0 && counter.Count >= limit {
+			return &QuotaExceededError{Window: window, Limit: limit, ResetsAt: resetsAt}
+		}
+		counter.Count++
+		counters[window] = counter
+	}
+	for window, counter := range counters {
+		q.Counters[window] = counter
+	}
+	q.UpdatedAt = now.Unix()
+	return nil
+}
+
+// RefundScan gives back a scan charged at chargedAt, in the windows whose
+// period has not ended since.
+func RefundScan(userID string, chargedAt time.Time) error {
+	ref := quotaRef(userID)
+	return utils.FirestoreClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		quota, err := readQuota(tx, ref)
+		if err != nil {
+			return err
+		}
+		quota.refund(chargedAt, time.Now())
+		return tx.Set(ref, quota)
+	})
+}
+
+// refund takes a scan charged at chargedAt off the counters of the windows
+// whose period has not ended since.
+func (q *Quota) refund(chargedAt, now time.Time) {
+	for _, window := range QuotaWindows {
+		period, _ := WindowPeriod(window, chargedAt)
+		if counter := q.Counters[window]; counter.Period == period && counter.Count > 0 {
+			counter.Count--
+			q.Counters[window] = counter
+		}
+	}
+	q.UpdatedAt = now.Unix()
+}
+
+// QuotaStatus returns the user's plan and how much of each window they have
+// used at now.
+func QuotaStatus(userID string, plans Plans, now time.Time) (string, map[string]QuotaWindowStatus, error) {
+	plan, err := UserPlan(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	limits := plans.For(plan)
+
+	var quota Quota
+	doc, err := quotaRef(userID).Get(context.Background())
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", nil, err
+	}
+	if err == nil {
+		if err := doc.DataTo(&quota); err != nil {
+			return "", nil, err
+		}
+	}
+
+	windows := make(map[string]QuotaWindowStatus, len(QuotaWindows))
+	for _, window := range QuotaWindows {
+		period, resetsAt := WindowPeriod(window, now)
+		windowStatus := QuotaWindowStatus{ResetsAt: resetsAt.Unix()}
+		if counter := quota.Counters[window]; counter.Period == period {
+			windowStatus.Used = counter.Count
+		}
+		if limit := limits[window]; limit > 0 {
+			remaining := max(limit-windowStatus.Used, 0)
+			windowStatus.Limit, windowStatus.Remaining = &limit, &remaining
+		}
+		windows[window] = windowStatus
+	}
+	return plan, windows, nil
+}
+
+// IsQuotaExceeded returns the *QuotaExceededError in err's chain, if any.
+func IsQuotaExceeded(err error) (*QuotaExceededError, bool) {
+	var exceeded *QuotaExceededError
+	ok := errors.As(err, &exceeded)
+	return exceeded, ok
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestWindowPeriod(t *testing.T) {
+	tests := []struct {
+		name         string
+		window       string
+		at           time.Time
+		wantPeriod   string
+		wantResetsAt time.Time
+	}{
+		{"day", WindowDaily, date(2026, 10, 18, 23), "2026-10-18", date(2026, 10, 19, 0)},
+		{"day at midnight", WindowDaily, date(2026, 10, 19, 0), "2026-10-19", date(2026, 10, 20, 0)},
+		{"last day of the year", WindowDaily, date(2024, 12, 31, 12), "2024-12-31", date(2025, 1, 1, 0)},
+		{"end of february", WindowDaily, date(2026, 2, 28, 12), "2026-02-28", date(2026, 3, 1, 0)},
+		{"leap day", WindowDaily, date(2028, 2, 29, 12), "2028-02-29", date(2028, 3, 1, 0)},
+
+		{"sunday", WindowWeekly, date(2026, 10, 18, 23), "2026-W42", date(2026, 10, 19, 0)},
+		{"monday", WindowWeekly, date(2026, 10, 19, 0), "2026-W43", date(2026, 10, 26, 0)},
+		{"midweek", WindowWeekly, date(2026, 10, 21, 12), "2026-W43", date(2026, 10, 26, 0)},
+		{"dec 31 in week 1", WindowWeekly, date(2024, 12, 31, 12), "2025-W01", date(2025, 1, 6, 0)},
+		{"jan 1 in week 53", WindowWeekly, date(2027, 1, 1, 12), "2026-W53", date(2027, 1, 4, 0)},
+
+		{"month", WindowMonthly, date(2026, 10, 18, 12), "2026-10", date(2026, 11, 1, 0)},
+		{"december", WindowMonthly, date(2024, 12, 31, 23), "2024-12", date(2025, 1, 1, 0)},
+		{"end of february", WindowMonthly, date(2026, 2, 28, 23), "2026-02", date(2026, 3, 1, 0)},
+		{"leap february", WindowMonthly, date(2028, 2, 29, 23), "2028-02", date(2028, 3, 1, 0)},
+		{"january 31", WindowMonthly, date(2026, 1, 31, 12), "2026-01", date(2026, 2, 1, 0)},
+
+		// Windows are counted in UTC whatever zone the time is in.
+		{"other zone", WindowDaily, time.Date(2026, 10, 18, 21, 0, 0, 0, time.FixedZone("UTC-5", -5*3600)),
+			"2026-10-19", date(2026, 10, 20, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.window+" "+test.name, func(t *testing.T) {
+			period, resetsAt := WindowPeriod(test.window, test.at)
+			if period != test.wantPeriod || !resetsAt.Equal(test.wantResetsAt) {
+				t.Errorf("WindowPeriod(%s, %v) = %s, %v, want %s, %v",
+					test.window, test.at, period, resetsAt, test.wantPeriod, test.wantResetsAt)
+			}
+		})
+	}
+}
+
+func TestQuotaCharge(t *testing.T) {
+	limits := Limits{WindowDaily: 2, WindowWeekly: 3, WindowMonthly: 0}
+	quota := Quota{Counters: map[string]QuotaCounter{}}
+	monday := date(2026, 10, 19, 9)
+
+	for i := 0; i < 2; i++ {
+		if err := quota.charge(limits, monday); err != nil {
+			t.Fatalf("charge %d error = %v", i+1, err)
+		}
+	}
+	err := quota.charge(limits, monday)
+	exceeded, ok := IsQuotaExceeded(err)
+	if !ok || exceeded.Window != WindowDaily || exceeded.Limit != 2 || !exceeded.ResetsAt.Equal(date(2026, 10, 20, 0)) {
+		t.Fatalf("third charge on a day error = %v, want the daily limit", err)
+	}
+	if counter := quota.Counters[WindowWeekly]; counter.Count != 2 {
+		t.Errorf("weekly count = %d after a refused charge, want 2", counter.Count)
+	}
+
+	// The next day starts a new daily count; the week is still counting.
+	tuesday := monday.AddDate(0, 0, 1)
+	if err := quota.charge(limits, tuesday); err != nil {
+		t.Fatalf("charge on the next day error = %v", err)
+	}
+	if counter := quota.Counters[WindowDaily]; counter != (QuotaCounter{Period: "2026-10-20", Count: 1}) {
+		t.Errorf("daily counter = %+v, want a new day at 1", counter)
+	}
+	err = quota.charge(limits, tuesday)
+	if exceeded, ok := IsQuotaExceeded(err); !ok || exceeded.Window != WindowWeekly {
+		t.Fatalf("fourth charge in a week error = %v, want the weekly limit", err)
+	}
+
+	// The monthly window has no limit but is still counted.
+	if counter := quota.Counters[WindowMonthly]; counter != (QuotaCounter{Period: "2026-10", Count: 3}) {
+		t.Errorf("monthly counter = %+v, want 3 in 2026-10", counter)
+	}
+}
+
+func TestQuotaRefund(t *testing.T) {
+	limits := Limits{WindowDaily: 5, WindowWeekly: 10, WindowMonthly: 30}
+	quota := Quota{Counters: map[string]QuotaCounter{}}
+	sunday := date(2026, 10, 18, 22)
+	for i := 0; i < 2; i++ {
+		if err := quota.charge(limits, sunday); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Refunded the same day, every window gets the scan back.
+	quota.refund(sunday, sunday.Add(time.Minute))
+	for window, counter := range quota.Counters {
+		if counter.Count != 1 {
+			t.Errorf("%s count = %d after a refund, want 1", window, counter.Count)
+		}
+	}
+
+	// A scan charged on Sunday and refunded on Monday, after a new charge:
+	// the day and week have moved on and keep Monday's count; the month
+	// gets it back.
+	monday := sunday.Add(4 * time.Hour)
+	if err := quota.charge(limits, monday); err != nil {
+		t.Fatal(err)
+	}
+	quota.refund(sunday, monday)
+	want := map[string]QuotaCounter{
+		WindowDaily:   {Period: "2026-10-19", Count: 1},
+		WindowWeekly:  {Period: "2026-W43", Count: 1},
+		WindowMonthly: {Period: "2026-10", Count: 1},
+	}
+	for window, counter := range want {
+		if quota.Counters[window] != counter {
+			t.Errorf("%s counter = %+v, want %+v", window, quota.Counters[window], counter)
+		}
+	}
+
+	// Counts never go below zero.
+	empty := Quota{Counters: map[string]QuotaCounter{}}
+	empty.refund(sunday, monday)
+	for window, counter := range empty.Counters {
+		if counter.Count != 0 {
+			t.Errorf("%s count = %d after refunding nothing", window, counter.Count)
+		}
+	}
+}
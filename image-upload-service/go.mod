@@ -2,11 +2,15 @@ module image-upload-service
 
 go 1.22.4
 
-require cloud.google.com/go/storage v1.42.0
+require (
+	cloud.google.com/go/storage v1.42.0
+	github.com/IBM/sarama v1.43.2
+	github.com/esimov/pigo v1.4.6
+	golang.org/x/image v0.18.0
+)
 
 require (
 	cloud.google.com/go/longrunning v0.5.7 // indirect
-	github.com/IBM/sarama v1.43.2 // indirect
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/eapache/go-resiliency v1.6.0 // indirect
@@ -65,12 +69,12 @@ require (
 	golang.org/x/oauth2 v0.21.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	google.golang.org/api v0.183.0 // direct
 	google.golang.org/genproto v0.0.0-20240528184218-531527333157 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20240604185151-ef581f913117 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
-	google.golang.org/grpc v1.64.0 // indirect
+	google.golang.org/grpc v1.64.0
 	google.golang.org/protobuf v1.34.1 // indirect
 )
@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// CropOptions control CropFace.
+type CropOptions struct {
+	// Padding is the margin kept around the face on each side, as a
+	// fraction of the face's width.
+	Padding float64
+	// Size is the width and height of the crop.
+	Size        int
+	JPEGQuality int
+}
+
+// CropOptionsFromEnv reads FACE_CROP_PADDING (default 0.4) and
+// FACE_CROP_SIZE (default 512), and encodes at IMAGE_JPEG_QUALITY.
+func CropOptionsFromEnv() CropOptions {
+	return CropOptions{
+		Padding:     envFloat("FACE_CROP_PADDING", 0.4),
+		Size:        envInt("FACE_CROP_SIZE", 512),
+		JPEGQuality: envInt("IMAGE_JPEG_QUALITY", 90),
+	}
+}
+
+// CropFace cuts a square around face out of img, with opts.Padding of margin,
+// turned so that the eyes are level when they were found, and scales it to
+// opts.Size. The square is kept inside the image where it fits; anything a
+// turn brings in from outside is white.
+func CropFace(img image.Image, face Face, opts CropOptions) (Encoded, error) {
+	bounds := img.Bounds()
+	side := float64(face.Width) * (1 + 2*opts.Padding)
+	side = math.Min(side, float64(min(bounds.Dx(), bounds.Dy())))
+	centerX := float64(face.X) + float64(face.Width)/2
+	centerY := float64(face.Y) + float64(face.Height)/2
+	centerX = math.Max(side/2, math.Min(centerX, float64(bounds.Dx())-side/2)) + float64(bounds.Min.X)
+	centerY = math.Max(side/2, math.Min(centerY, float64(bounds.Dy())-side/2)) + float64(bounds.Min.Y)
+
+	angle := 0.0
+	if face.EyesFound {
+		angle = math.Atan2(float64(face.RightEye.Y-face.LeftEye.Y), float64(face.RightEye.X-face.LeftEye.X))
+	}
+
+	// Map the source so the crop's center lands in the middle of the output,
+	// turned back by the eyes' angle and scaled to the output size.
+	size := float64(opts.Size)
+	scale := size / side
+	cos, sin := math.Cos(angle)*scale, math.Sin(angle)*scale
+	sourceToCrop := f64.Aff3{
+		cos, sin, size/2 - (cos*centerX + sin*centerY),
+		-sin, cos, size/2 - (-sin*centerX + cos*centerY),
+	}
+
+	crop := image.NewRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	draw.Draw(crop, crop.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Transform(crop, sourceToCrop, img, bounds, xdraw.Over, nil)
+	return encodeJPEG(crop, opts.JPEGQuality)
+}
@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{R: 255, A: 255}
+	blue  = color.RGBA{B: 255, A: 255}
+	black = color.RGBA{A: 255}
+)
+
+func fill(img draw.Image, rect image.Rectangle, c color.Color) {
+	draw.Draw(img, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func decodeCrop(t *testing.T, encoded Encoded, size int) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(encoded.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != size || bounds.Dy() != size || encoded.Width != size || encoded.Height != size {
+		t.Fatalf("crop is %v, recorded as %dx%d, want %dx%d", bounds, encoded.Width, encoded.Height, size, size)
+	}
+	return img
+}
+
+// near reports whether a decoded pixel is close to want, allowing for JPEG
+// artifacts.
+func near(got, want color.Color) bool {
+	r1, g1, b1, _ := got.RGBA()
+	r2, g2, b2, _ := want.RGBA()
+	within := func(a, b uint32) bool { return a>>8 < b>>8+48 && b>>8 < a>>8+48 }
+	return within(r1, r2) && within(g1, g2) && within(b1, b2)
+}
+
+func TestCropFaceCentersTheFace(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 800))
+	fill(img, img.Bounds(), black)
+	face := Face{X: 400, Y: 300, Width: 200, Height: 200}
+	fill(img, image.Rect(400, 300, 600, 500), red)
+
+	crop := decodeCrop(t, mustCrop(t, img, face, CropOptions{Padding: 0.4, Size: 512, JPEGQuality: 90}), 512)
+	// The face is 200 of the crop's 360 pixels, so it spans 284 of 512.
+	if c := crop.At(256, 256); !near(c, red) {
+		t.Errorf("center of the crop is %v, want the face", c)
+	}
+	for _, point := range []image.Point{{40, 256}, {472, 256}, {256, 40}, {256, 472}} {
+		if c := crop.At(point.X, point.Y); !near(c, black) {
+			t.Errorf("padding at %v is %v, want the background", point, c)
+		}
+	}
+}
+
+func TestCropFaceStaysInsideTheImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 800))
+	fill(img, img.Bounds(), black)
+	// A face in the corner: the square moves in rather than taking in
+	// white from outside the image.
+	face := Face{X: 0, Y: 0, Width: 200, Height: 200}
+	fill(img, image.Rect(0, 0, 200, 200), red)
+
+	crop := decodeCrop(t, mustCrop(t, img, face, CropOptions{Padding: 0.4, Size: 256, JPEGQuality: 90}), 256)
+	if c := crop.At(10, 10); !near(c, red) {
+		t.Errorf("top left of the crop is %v, want the face", c)
+	}
+	if c := crop.At(245, 245); !near(c, black) {
+		t.Errorf("bottom right of the crop is %v, want the background", c)
+	}
+}
+
+func TestCropFaceLevelsTheEyes(t *testing.T) {
+	// Red above blue, with the eyes one above the other: the photo was
+	// taken on its side, so the crop turns the boundary upright.
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1000))
+	fill(img, image.Rect(0, 0, 1000, 500), red)
+	fill(img, image.Rect(0, 500, 1000, 1000), blue)
+	face := Face{
+		X: 400, Y: 400, Width: 200, Height: 200,
+		LeftEye: image.Pt(500, 450), RightEye: image.Pt(500, 550), EyesFound: true,
+	}
+
+	crop := decodeCrop(t, mustCrop(t, img, face, CropOptions{Padding: 0.4, Size: 256, JPEGQuality: 90}), 256)
+	left, right := crop.At(40, 40), crop.At(216, 40)
+	if !near(crop.At(40, 216), left) || !near(crop.At(216, 216), right) {
+		t.Errorf("the boundary is not upright: corners %v %v %v %v",
+			left, right, crop.At(40, 216), crop.At(216, 216))
+	}
+	if near(left, right) {
+		t.Errorf("both sides are %v, want red on one and blue on the other", left)
+	}
+}
+
+func mustCrop(t *testing.T, img image.Image, face Face, opts CropOptions) Encoded {
+	t.Helper()
+	encoded, err := CropFace(img, face, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
@@ -0,0 +1,189 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// orientationTag is the EXIF tag holding how the camera was held.
+const orientationTag = 0x0112
+
+// Orientation reads the EXIF orientation of a JPEG, PNG or WebP image: 1 is
+// upright, 2 to 8 are the mirrored and rotated variants. Images without EXIF
+// data are upright.
+func Orientation(data []byte, format Format) int {
+	tiff := exifData(data, format)
+	if tiff == nil {
+		return 1
+	}
+	orientation := tiffOrientation(tiff)
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// exifData finds the TIFF structure holding an image's EXIF data.
+func exifData(data []byte, format Format) []byte {
+	switch format {
+	case JPEG:
+		for _, segment := range jpegSegments(data) {
+			if segment.marker == 0xE1 && bytes.HasPrefix(segment.payload, []byte("Exif\x00\x00")) {
+				return segment.payload[6:]
+			}
+		}
+	case PNG:
+		for _, chunk := range pngChunks(data) {
+			if chunk.kind == "eXIf" {
+				return chunk.payload
+			}
+		}
+	case WebP:
+		for _, chunk := range webpChunks(data) {
+			if chunk.kind == "EXIF" {
+				// Some writers keep the JPEG "Exif" header.
+				return bytes.TrimPrefix(chunk.payload, []byte("Exif\x00\x00"))
+			}
+		}
+	}
+	return nil
+}
+
+// tiffOrientation looks up the orientation tag in the first IFD.
+func tiffOrientation(tiff []byte) int {
+	order, offset := tiffHeader(tiff)
+	if order == nil {
+		return 0
+	}
+	for _, entry := range ifdEntries(tiff, order, offset) {
+		if order.Uint16(tiff[entry:]) == orientationTag {
+			// A SHORT value sits in the first two bytes of the value field.
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// tiffHeader returns a TIFF structure's byte order and the offset of its
+// first IFD, or a nil order if it is not one.
+func tiffHeader(tiff []byte) (binary.ByteOrder, int) {
+	if len(tiff) < 8 {
+		return nil, 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0
+	}
+	return order, int(order.Uint32(tiff[4:8]))
+}
+
+// ifdEntries returns the positions of the 12 byte entries of the IFD at
+// offset, leaving out any that run past the data.
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset int) []int {
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	var entries []int
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+	// start and end are the segment's position in the file, marker included.
+	start, end int
+}
+
+// jpegSegments lists the marker segments before the image data.
+func jpegSegments(data []byte) []jpegSegment {
+	var segments []jpegSegment
+	position := 2
+	for position+4 <= len(data) && data[position] == 0xFF {
+		marker := data[position+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			position += 2
+			continue
+		}
+		if marker == 0xFF {
+			position++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[position+2:]))
+		end := position + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker: marker, payload: data[position+4 : end], start: position, end: end})
+		if marker == 0xDA {
+			// Start of scan: entropy coded data follows.
+			break
+		}
+		position = end
+	}
+	return segments
+}
+
+type chunk struct {
+	kind    string
+	payload []byte
+	// start and end are the chunk's position in the file, header included.
+	start, end int
+}
+
+// pngChunks lists a PNG's chunks.
+func pngChunks(data []byte) []chunk {
+	var chunks []chunk
+	position := 8
+	for position+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		end := position + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		chunks = append(chunks, chunk{
+			kind:    string(data[position+4 : position+8]),
+			payload: data[position+8 : position+8+length],
+			start:   position,
+			end:     end,
+		})
+		position = end
+	}
+	return chunks
+}
+
+// webpChunks lists the chunks inside a WebP's RIFF container.
+func webpChunks(data []byte) []chunk {
+	var chunks []chunk
+	position := 12
+	for position+8 <= len(data) {
+		length := int(binary.LittleEndian.Uint32(data[position+4:]))
+		end := position + 8 + length + length%2
+		if length < 0 || position+8+length > len(data) {
+			break
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, chunk{
+			kind:    string(data[position : position+4]),
+			payload: data[position+8 : position+8+length],
+			start:   position,
+			end:     end,
+		})
+		position = end
+	}
+	return chunks
+}
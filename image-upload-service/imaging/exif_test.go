@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// tiffWithOrientation builds an EXIF TIFF block whose first IFD holds only
+// the orientation tag.
+func tiffWithOrientation(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	entry := tiff[10:]
+	order.PutUint16(entry[0:], orientationTag)
+	order.PutUint16(entry[2:], 3) // SHORT
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], uint16(orientation))
+	return tiff
+}
+
+func jpegWithEXIF(tiff []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(data[4:], uint16(len(payload)+2))
+	data = append(data, payload...)
+	// Start of scan, so the file looks complete to the segment reader.
+	return append(data, 0xFF, 0xDA, 0x00, 0x02, 0x00)
+}
+
+func pngWithEXIF(tiff []byte) []byte {
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = appendPNGChunk(data, "IHDR", make([]byte, 13))
+	data = appendPNGChunk(data, "eXIf", tiff)
+	return appendPNGChunk(data, "IEND", nil)
+}
+
+func appendPNGChunk(data []byte, kind string, payload []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+	data = append(data, kind...)
+	data = append(data, payload...)
+	// Nothing here checks the CRC.
+	return append(data, 0, 0, 0, 0)
+}
+
+func webpWithEXIF(exif []byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	data = appendWebPChunk(data, "VP8X", []byte{webpEXIFFlag, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	data = appendWebPChunk(data, "EXIF", exif)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func appendWebPChunk(data []byte, kind string, payload []byte) []byte {
+	data = append(data, kind...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(payload)))
+	data = append(data, payload...)
+	if len(payload)%2 == 1 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+func TestOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := 1; orientation <= 8; orientation++ {
+			tiff := tiffWithOrientation(order, orientation)
+			files := map[Format][]byte{
+				JPEG: jpegWithEXIF(tiff),
+				PNG:  pngWithEXIF(tiff),
+				WebP: webpWithEXIF(tiff),
+			}
+			for format, data := range files {
+				if got := Orientation(data, format); got != orientation {
+					t.Errorf("Orientation(%s %v, %d) = %d", format, order, orientation, got)
+				}
+			}
+			// WebP writers may keep the JPEG Exif header.
+			withHeader := webpWithEXIF(append([]byte("Exif\x00\x00"), tiff...))
+			if got := Orientation(withHeader, WebP); got != orientation {
+				t.Errorf("Orientation(webp with Exif header %v, %d) = %d", order, orientation, got)
+			}
+		}
+	}
+}
+
+func TestOrientationDefaultsToUpright(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+	}{
+		{"no exif", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, JPEG},
+		{"out of range", jpegWithEXIF(tiffWithOrientation(binary.LittleEndian, 9)), JPEG},
+		{"zero", jpegWithEXIF(tiffWithOrientation(binary.BigEndian, 0)), JPEG},
+		{"not tiff", jpegWithEXIF([]byte("XX*\x00\x08\x00\x00\x00")), JPEG},
+		{"truncated ifd", jpegWithEXIF(tiffWithOrientation(binary.LittleEndian, 6)[:16]), JPEG},
+		{"gif", []byte("GIF89a"), GIF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Orientation(test.data, test.format); got != 1 {
+				t.Errorf("Orientation() = %d, want 1", got)
+			}
+		})
+	}
+}
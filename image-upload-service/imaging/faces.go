@@ -0,0 +1,200 @@
+package imaging
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	pigo "github.com/esimov/pigo/core"
+)
+
+// facefinder and puploc are pigo's frontal face and pupil cascades, from
+// github.com/esimov/pigo/cascade (MIT licensed).
+var (
+	//go:embed cascade/facefinder
+	facefinder []byte
+	//go:embed cascade/puploc
+	puploc []byte
+)
+
+// Reasons CheckFace turns an image down.
+var (
+	ErrNoFace        = errors.New("no face found")
+	ErrMultipleFaces = errors.New("more than one face found")
+	ErrFaceTooSmall  = errors.New("face is too small")
+)
+
+// detectionDimension is the size images are scaled down to for detection,
+// which keeps it fast on CPU; faces found are mapped back to full size.
+const detectionDimension = 640
+
+// Face is a face's bounding box in the pixels of the image it was found in,
+// with the detector's confidence. The centers of the pupils are set when
+// they were found; LeftEye is the one on the left of the image.
+type Face struct {
+	X         int
+	Y         int
+	Width     int
+	Height    int
+	Score     float32
+	LeftEye   image.Point
+	RightEye  image.Point
+	EyesFound bool
+}
+
+// FaceOptions control CheckFace.
+type FaceOptions struct {
+	// MinFaceRatio is the smallest face accepted, as a fraction of the
+	// image's shorter side.
+	MinFaceRatio float64
+	// MinScore is the confidence a detection needs to count as a face.
+	MinScore float32
+	// BackgroundFaceRatio lets through other faces narrower than this
+	// fraction of the largest, taking them to be in the background. At 0
+	// any second face turns the image down.
+	BackgroundFaceRatio float64
+}
+
+// FaceOptionsFromEnv reads FACE_MIN_RATIO (default 0.15), FACE_MIN_SCORE
+// (default 5) and FACE_BACKGROUND_RATIO (default 0).
+func FaceOptionsFromEnv() FaceOptions {
+	return FaceOptions{
+		MinFaceRatio:        envFloat("FACE_MIN_RATIO", 0.15),
+		MinScore:            float32(envFloat("FACE_MIN_SCORE", 5)),
+		BackgroundFaceRatio: envFloat("FACE_BACKGROUND_RATIO", 0),
+	}
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+var (
+	classifier      *pigo.Pigo
+	pupilLocator    *pigo.PuplocCascade
+	classifierErr   error
+	loadClassifiers sync.Once
+)
+
+// DetectFaces finds the upright faces in img scoring at least minScore,
+// largest first.
+func DetectFaces(img image.Image, minScore float32) ([]Face, error) {
+	loadClassifiers.Do(func() {
+		if classifier, classifierErr = pigo.NewPigo().Unpack(facefinder); classifierErr != nil {
+			return
+		}
+		pupilLocator, classifierErr = pigo.NewPuplocCascade().UnpackCascade(puploc)
+	})
+	if classifierErr != nil {
+		return nil, fmt.Errorf("loading face cascades: %w", classifierErr)
+	}
+
+	bounds := img.Bounds()
+	small := Fit(img, detectionDimension)
+	width, height := small.Bounds().Dx(), small.Bounds().Dy()
+	scale := float64(bounds.Dx()) / float64(width)
+
+	params := pigo.ImageParams{
+		Pixels: pigo.RgbToGrayscale(small),
+		Rows:   height,
+		Cols:   width,
+		Dim:    width,
+	}
+	detections := classifier.RunCascade(pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     min(width, height),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: params,
+	}, 0)
+	detections = classifier.ClusterDetections(detections, 0.2)
+
+	faces := []Face{}
+	for _, detection := range detections {
+		if detection.Q < minScore {
+			continue
+		}
+		size := int(float64(detection.Scale) * scale)
+		face := Face{
+			X:      int(float64(detection.Col-detection.Scale/2) * scale),
+			Y:      int(float64(detection.Row-detection.Scale/2) * scale),
+			Width:  size,
+			Height: size,
+			Score:  detection.Q,
+		}
+		left, leftFound := findPupil(params, detection, -1)
+		right, rightFound := findPupil(params, detection, 1)
+		if leftFound && rightFound && plausibleEyes(left, right, detection.Scale) {
+			face.LeftEye = image.Pt(int(float64(left.X)*scale), int(float64(left.Y)*scale))
+			face.RightEye = image.Pt(int(float64(right.X)*scale), int(float64(right.Y)*scale))
+			face.EyesFound = true
+		}
+		faces = append(faces, face)
+	}
+	sort.Slice(faces, func(i, j int) bool { return faces[i].Width > faces[j].Width })
+	return faces, nil
+}
+
+// findPupil looks for the pupil on one side of a detected face, side -1
+// being the left of the image, starting where eyes usually are.
+func findPupil(params pigo.ImageParams, detection pigo.Detection, side int) (image.Point, bool) {
+	pupil := pupilLocator.RunDetector(pigo.Puploc{
+		Row:      detection.Row - int(0.085*float32(detection.Scale)),
+		Col:      detection.Col + side*int(0.185*float32(detection.Scale)),
+		Scale:    float32(detection.Scale) * 0.4,
+		Perturbs: 63,
+	}, params, 0, false)
+	return image.Pt(pupil.Col, pupil.Row), pupil.Row > 0 && pupil.Col > 0
+}
+
+// plausibleEyes rejects pupils that cannot belong to an upright face of the
+// given size: in the wrong order, too close or far apart, or tilted by more
+// than about 30 degrees.
+func plausibleEyes(left, right image.Point, faceSize int) bool {
+	dx, dy := right.X-left.X, right.Y-left.Y
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx > faceSize/5 && dx < faceSize*7/10 && dy*5 < dx*3
+}
+
+// CheckFace makes sure img shows exactly one face that is large enough, and
+// returns it. Other faces only pass when opts.BackgroundFaceRatio allows them.
+func CheckFace(img image.Image, opts FaceOptions) (Face, error) {
+	faces, err := DetectFaces(img, opts.MinScore)
+	if err != nil {
+		return Face{}, err
+	}
+	return checkFaces(faces, img.Bounds(), opts)
+}
+
+// checkFaces picks the face of an image with the given bounds from the faces
+// found in it, largest first.
+func checkFaces(faces []Face, bounds image.Rectangle, opts FaceOptions) (Face, error) {
+	if len(faces) == 0 {
+		return Face{}, ErrNoFace
+	}
+	face := faces[0]
+	if len(faces) > 1 && float64(faces[1].Width) >= opts.BackgroundFaceRatio*float64(face.Width) {
+		return Face{}, ErrMultipleFaces
+	}
+	if float64(face.Width) < opts.MinFaceRatio*float64(min(bounds.Dx(), bounds.Dy())) {
+		return Face{}, ErrFaceTooSmall
+	}
+	return face, nil
+}
@@ -0,0 +1,52 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestCheckFaces(t *testing.T) {
+	bounds := image.Rect(0, 0, 1000, 800)
+	face := func(width int) Face {
+		return Face{X: 100, Y: 100, Width: width, Height: width, Score: 10}
+	}
+	opts := FaceOptions{MinFaceRatio: 0.15}
+	background := FaceOptions{MinFaceRatio: 0.15, BackgroundFaceRatio: 0.25}
+
+	tests := []struct {
+		name    string
+		faces   []Face
+		opts    FaceOptions
+		want    Face
+		wantErr error
+	}{
+		{"one face", []Face{face(400)}, opts, face(400), nil},
+		{"no face", nil, opts, Face{}, ErrNoFace},
+		{"two faces", []Face{face(400), face(300)}, opts, Face{}, ErrMultipleFaces},
+		{"small second face", []Face{face(400), face(40)}, opts, Face{}, ErrMultipleFaces},
+		{"background face allowed", []Face{face(400), face(40)}, background, face(400), nil},
+		{"second face too large for background", []Face{face(400), face(100)}, background, Face{}, ErrMultipleFaces},
+		// 0.15 of the shorter side, 800 pixels.
+		{"face at the minimum", []Face{face(120)}, opts, face(120), nil},
+		{"face too small", []Face{face(119)}, opts, Face{}, ErrFaceTooSmall},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := checkFaces(test.faces, bounds, test.opts)
+			if !errors.Is(err, test.wantErr) || got != test.want {
+				t.Errorf("checkFaces() = %+v, %v, want %+v, %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckFaceFindsNoFaceInBlankImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	if _, err := CheckFace(img, FaceOptions{MinFaceRatio: 0.15, MinScore: 5}); !errors.Is(err, ErrNoFace) {
+		t.Errorf("CheckFace(blank) error = %v, want ErrNoFace", err)
+	}
+}
@@ -0,0 +1,46 @@
+// Package imaging holds the image-upload-service's image processing stages.
+// They work on bytes and image.Image values and know nothing about Kafka,
+// Cloud Storage or Firestore.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Format is an image format recognised by its magic bytes.
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	GIF  Format = "gif"
+	WebP Format = "webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("not a JPEG, PNG, GIF or WebP image")
+	ErrInvalidImage      = errors.New("image could not be decoded")
+	ErrTooManyPixels     = errors.New("image has too many pixels")
+)
+
+// Sniff identifies data's format from its first bytes, whatever content type
+// the client claimed.
+func Sniff(data []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG, nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, nil
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return GIF, nil
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return WebP, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// ContentType is the MIME type of the format.
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Format
+	}{
+		{"jpeg", "\xFF\xD8\xFF\xE0\x00\x10JFIF", JPEG},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", PNG},
+		{"gif87a", "GIF87a\x01\x00\x01\x00", GIF},
+		{"gif89a", "GIF89a\x01\x00\x01\x00", GIF},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8 ", WebP},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := Sniff([]byte(test.data))
+			if err != nil || format != test.want {
+				t.Errorf("Sniff() = %q, %v, want %q", format, err, test.want)
+			}
+		})
+	}
+}
+
+func TestSniffRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"text", "hello, world"},
+		{"html claiming to be an image", "<html><img src=x>"},
+		{"bmp", "BM\x36\x00\x00\x00"},
+		{"tiff", "II*\x00\x08\x00\x00\x00"},
+		{"heic", "\x00\x00\x00\x18ftypheic"},
+		{"svg", "<svg xmlns=\"http://www.w3.org/2000/svg\"/>"},
+		{"truncated jpeg", "\xFF\xD8"},
+		{"truncated png", "\x89PNG\r\n"},
+		{"gif of an unknown version", "GIF90a"},
+		{"wav", "RIFF\x24\x00\x00\x00WAVEfmt "},
+		{"truncated riff", "RIFF\x24\x00\x00\x00WEB"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := Sniff([]byte(test.data))
+			if !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("Sniff() = %q, %v, want ErrUnsupportedFormat", format, err)
+			}
+		})
+	}
+}
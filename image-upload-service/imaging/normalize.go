@@ -0,0 +1,143 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"strconv"
+
+	// Decoders for the accepted formats.
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// RenditionSpec is a resized copy made of every image.
+type RenditionSpec struct {
+	Name         string
+	MaxDimension int
+}
+
+// Options control Normalize.
+type Options struct {
+	// MaxDimension caps the longer side of the stored image.
+	MaxDimension int
+	// MaxPixels bounds the decoded size, which guards against images that
+	// are small files but huge bitmaps.
+	MaxPixels   int
+	JPEGQuality int
+	Renditions  []RenditionSpec
+}
+
+// OptionsFromEnv reads IMAGE_MAX_DIMENSION (default 2048), IMAGE_MAX_PIXELS
+// (default 50 million) and IMAGE_JPEG_QUALITY (default 90). Every image gets
+// a 256 pixel thumbnail and a 1024 pixel medium rendition.
+func OptionsFromEnv() Options {
+	return Options{
+		MaxDimension: envInt("IMAGE_MAX_DIMENSION", 2048),
+		MaxPixels:    envInt("IMAGE_MAX_PIXELS", 50_000_000),
+		JPEGQuality:  envInt("IMAGE_JPEG_QUALITY", 90),
+		Renditions: []RenditionSpec{
+			{Name: "thumbnail", MaxDimension: 256},
+			{Name: "medium", MaxDimension: 1024},
+		},
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Encoded is a JPEG produced by Normalize.
+type Encoded struct {
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Normalized is an uploaded image in canonical form.
+type Normalized struct {
+	// SourceFormat is the format the upload really was.
+	SourceFormat Format
+	// MetadataRemoved lists the metadata categories Scrub found in the upload.
+	MetadataRemoved []string
+	// Image is the upright, size capped image that Original encodes.
+	Image      image.Image
+	Original   Encoded
+	Renditions map[string]Encoded
+}
+
+// Normalize decodes an upload, checking its real format, turns it upright
+// from its EXIF orientation, caps its resolution and re-encodes it as a JPEG,
+// along with the renditions in opts. Metadata is scrubbed from the upload
+// before it is decoded, and the output carries none. It returns
+// ErrUnsupportedFormat, ErrInvalidImage or ErrTooManyPixels for uploads it
+// cannot use.
+func Normalize(data []byte, opts Options) (*Normalized, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	// The orientation is the one piece of metadata that is used, so it is
+	// read before the rest is dropped.
+	orientation := Orientation(data, format)
+	data, metadataRemoved, err := Scrub(data, format)
+	if err != nil {
+		return nil, err
+	}
+	config, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || Format(decodedFormat) != format {
+		return nil, ErrInvalidImage
+	}
+	if opts.MaxPixels > 0 && config.Width*config.Height > opts.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	// Scaling first leaves fewer pixels to turn; the longer side is the same
+	// either way.
+	img = flatten(Orient(Fit(img, opts.MaxDimension), orientation))
+
+	normalized := &Normalized{
+		SourceFormat:    format,
+		MetadataRemoved: metadataRemoved,
+		Image:           img,
+		Renditions:      make(map[string]Encoded, len(opts.Renditions)),
+	}
+	if normalized.Original, err = encodeJPEG(img, opts.JPEGQuality); err != nil {
+		return nil, err
+	}
+	for _, spec := range opts.Renditions {
+		rendition, err := encodeJPEG(Fit(img, spec.MaxDimension), opts.JPEGQuality)
+		if err != nil {
+			return nil, err
+		}
+		normalized.Renditions[spec.Name] = rendition
+	}
+	return normalized, nil
+}
+
+func encodeJPEG(img image.Image, quality int) (Encoded, error) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality}); err != nil {
+		return Encoded{}, err
+	}
+	bounds := img.Bounds()
+	return Encoded{Data: buffer.Bytes(), Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Perceptual hashes are 64 bit summaries of how an image looks. Unlike a
+// checksum they barely change when an image is re-encoded, resized, lightly
+// cropped or color corrected, so the Hamming distance between two hashes says
+// how alike the images are.
+
+// PHash is the DCT based perceptual hash: the signs of the lowest 8x8
+// frequencies of a 32x32 grayscale copy, compared with their median.
+func PHash(img image.Image) uint64 {
+	const size, low = 32, 8
+	pixels := grayscale(img, size, size)
+
+	// 2D DCT-II, keeping only the low frequencies.
+	var frequencies [low * low]float64
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += pixels[y*size+x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+			frequencies[v*low+u] = sum
+		}
+	}
+
+	// The DC term is the overall brightness; leave it out of the median.
+	sorted := append([]float64(nil), frequencies[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, frequency := range frequencies {
+		if frequency > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// DHash is the difference hash: whether each pixel of a 9x8 grayscale copy
+// is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+// Hamming is the number of bits two hashes differ in.
+func Hamming(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// HashBands splits a hash into its eight bytes, each labelled with kind and
+// its position. Two hashes within 7 bits of each other share at least one
+// band, so looking up images that share a band finds every candidate match.
+func HashBands(kind string, hash uint64) []string {
+	bands := make([]string, 8)
+	for i := range bands {
+		bands[i] = fmt.Sprintf("%s%d:%02x", kind, i, byte(hash>>(8*i)))
+	}
+	return bands
+}
+
+// FormatHash and ParseHash convert hashes to and from 16 hex digits.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParseHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// grayscale shrinks an image to width x height and returns its luminance,
+// row by row.
+func grayscale(img image.Image, width, height int) []float64 {
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	pixels := make([]float64, width*height)
+	for i := range pixels {
+		r, g, b := small.Pix[i*4], small.Pix[i*4+1], small.Pix[i*4+2]
+		pixels[i] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	}
+	return pixels
+}
@@ -0,0 +1,72 @@
+package imaging
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// flipBits flips n distinct random bits of hash.
+func flipBits(random *rand.Rand, hash uint64, n int) uint64 {
+	for _, bit := range random.Perm(64)[:n] {
+		hash ^= 1 << uint(bit)
+	}
+	return hash
+}
+
+func sharesBand(a, b []string) bool {
+	seen := make(map[string]bool, len(a))
+	for _, band := range a {
+		seen[band] = true
+	}
+	for _, band := range b {
+		if seen[band] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHashBandsFindCloseHashes(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for distance := 0; distance <= 7; distance++ {
+		for i := 0; i < 1000; i++ {
+			hash := random.Uint64()
+			near := flipBits(random, hash, distance)
+			if Hamming(hash, near) != distance {
+				t.Fatalf("Hamming(%016x, %016x) = %d, want %d", hash, near, Hamming(hash, near), distance)
+			}
+			if !sharesBand(HashBands("p", hash), HashBands("p", near)) {
+				t.Fatalf("%016x and %016x differ in %d bits but share no band", hash, near, distance)
+			}
+		}
+	}
+}
+
+func TestHashBandsLimit(t *testing.T) {
+	// One bit in every byte is the smallest change that shares no band, which
+	// is why matches are only guaranteed up to 7 bits.
+	hash := uint64(0x0123456789abcdef)
+	far := hash ^ 0x0101010101010101
+	if sharesBand(HashBands("p", hash), HashBands("p", far)) {
+		t.Error("hashes differing in every byte share a band")
+	}
+}
+
+func TestHashBandsKeepKindsApart(t *testing.T) {
+	if sharesBand(HashBands("p", 42), HashBands("d", 42)) {
+		t.Error("the same hash of two kinds shares a band")
+	}
+}
+
+func TestFormatHashRoundTrip(t *testing.T) {
+	for _, hash := range []uint64{0, 1, 0x0123456789abcdef, ^uint64(0)} {
+		formatted := FormatHash(hash)
+		if len(formatted) != 16 {
+			t.Errorf("FormatHash(%x) = %q, want 16 digits", hash, formatted)
+		}
+		parsed, err := ParseHash(formatted)
+		if err != nil || parsed != hash {
+			t.Errorf("ParseHash(%q) = %x, %v, want %x", formatted, parsed, err, hash)
+		}
+	}
+}
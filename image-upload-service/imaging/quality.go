@@ -0,0 +1,196 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Severities of a QualityIssue.
+const (
+	// SeverityReject issues keep an image from being scored.
+	SeverityReject = "reject"
+	// SeverityWarn issues are reported, but the image is scored.
+	SeverityWarn = "warn"
+)
+
+// QualityIssue is something wrong with a photo that a retake can fix.
+type QualityIssue struct {
+	// Code is one of blurry, too_dark, too_bright, low_contrast,
+	// low_resolution and face_too_small.
+	Code     string
+	Severity string
+	Message  string
+}
+
+// Quality holds the measurements of a photo. Sharpness, Brightness and
+// Contrast are taken over the face when one was found, and over the whole
+// image otherwise.
+type Quality struct {
+	// Sharpness is the variance of the Laplacian of the region scaled to
+	// qualityDimension pixels; blur lowers it.
+	Sharpness float64
+	// Brightness is the mean luminance, from 0 to 255.
+	Brightness float64
+	// Contrast is the standard deviation of the luminance.
+	Contrast float64
+	// Resolution is the image's shorter side in pixels.
+	Resolution int
+	// FaceSize is the face's width in pixels, 0 without a face.
+	FaceSize int
+	Issues   []QualityIssue
+}
+
+// Rejected returns the issues that keep the image from being scored.
+func (q Quality) Rejected() []QualityIssue {
+	var rejected []QualityIssue
+	for _, issue := range q.Issues {
+		if issue.Severity == SeverityReject {
+			rejected = append(rejected, issue)
+		}
+	}
+	return rejected
+}
+
+// Threshold is the value at which a measurement gets an image rejected, and
+// the value at which it gets a warning. For measurements that may be too
+// high, such as brightness, they are maximums; otherwise minimums.
+type Threshold struct {
+	Reject float64
+	Warn   float64
+}
+
+// QualityOptions control AssessQuality.
+type QualityOptions struct {
+	Sharpness     Threshold
+	MinBrightness Threshold
+	MaxBrightness Threshold
+	Contrast      Threshold
+	Resolution    Threshold
+	FaceSize      Threshold
+}
+
+// QualityOptionsFromEnv reads each threshold as "reject,warn" from
+// QUALITY_SHARPNESS (default 15,40), QUALITY_MIN_BRIGHTNESS (40,70),
+// QUALITY_MAX_BRIGHTNESS (225,200), QUALITY_CONTRAST (15,30),
+// QUALITY_RESOLUTION (240,480) and QUALITY_FACE_SIZE (64,128).
+func QualityOptionsFromEnv() QualityOptions {
+	return QualityOptions{
+		Sharpness:     envThreshold("QUALITY_SHARPNESS", Threshold{15, 40}),
+		MinBrightness: envThreshold("QUALITY_MIN_BRIGHTNESS", Threshold{40, 70}),
+		MaxBrightness: envThreshold("QUALITY_MAX_BRIGHTNESS", Threshold{225, 200}),
+		Contrast:      envThreshold("QUALITY_CONTRAST", Threshold{15, 30}),
+		Resolution:    envThreshold("QUALITY_RESOLUTION", Threshold{240, 480}),
+		FaceSize:      envThreshold("QUALITY_FACE_SIZE", Threshold{64, 128}),
+	}
+}
+
+func envThreshold(name string, fallback Threshold) Threshold {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	reject, warn, found := strings.Cut(value, ",")
+	var threshold Threshold
+	var rejectErr, warnErr error
+	threshold.Reject, rejectErr = strconv.ParseFloat(strings.TrimSpace(reject), 64)
+	threshold.Warn, warnErr = strconv.ParseFloat(strings.TrimSpace(warn), 64)
+	if !found || rejectErr != nil || warnErr != nil || threshold.Reject < 0 || threshold.Warn < 0 {
+		log.Printf("Invalid %s %q, using %v,%v", name, value, fallback.Reject, fallback.Warn)
+		return fallback
+	}
+	return threshold
+}
+
+// subImager is implemented by the image types that can share a region of
+// their pixels, which all the decoded types do.
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// qualityDimension is the size the measured region is scaled to, so that
+// sharpness compares alike between large and small photos.
+const qualityDimension = 256
+
+// AssessQuality measures img and lists its issues. face may be nil when no
+// face was found.
+func AssessQuality(img image.Image, face *Face, opts QualityOptions) Quality {
+	bounds := img.Bounds()
+	quality := Quality{Resolution: min(bounds.Dx(), bounds.Dy())}
+
+	region := img
+	if face != nil {
+		quality.FaceSize = face.Width
+		box := image.Rect(face.X, face.Y, face.X+face.Width, face.Y+face.Height).Add(bounds.Min).Intersect(bounds)
+		if sub, ok := img.(subImager); ok && !box.Empty() {
+			region = sub.SubImage(box)
+		}
+	}
+
+	width, height := qualityDimension, qualityDimension
+	if regionBounds := region.Bounds(); regionBounds.Dx() > regionBounds.Dy() {
+		height = max(1, qualityDimension*regionBounds.Dy()/regionBounds.Dx())
+	} else {
+		width = max(1, qualityDimension*regionBounds.Dx()/regionBounds.Dy())
+	}
+	pixels := grayscale(region, width, height)
+	quality.Brightness, quality.Contrast = meanDeviation(pixels)
+	quality.Sharpness = laplacianVariance(pixels, width, height)
+
+	check := func(code string, value float64, threshold Threshold, tooHigh bool, message string) {
+		fails := func(limit float64) bool {
+			if tooHigh {
+				return value > limit
+			}
+			return value < limit
+		}
+		switch {
+		case fails(threshold.Reject):
+			quality.Issues = append(quality.Issues, QualityIssue{Code: code, Severity: SeverityReject, Message: message})
+		case fails(threshold.Warn):
+			quality.Issues = append(quality.Issues, QualityIssue{Code: code, Severity: SeverityWarn, Message: message})
+		}
+	}
+	check("blurry", quality.Sharpness, opts.Sharpness, false, "The photo is blurry, hold the camera still and focus on the face")
+	check("too_dark", quality.Brightness, opts.MinBrightness, false, "The photo is too dark, find more light")
+	check("too_bright", quality.Brightness, opts.MaxBrightness, true, "The photo is overexposed, avoid direct light")
+	check("low_contrast", quality.Contrast, opts.Contrast, false, "The photo is washed out, avoid haze and backlight")
+	check("low_resolution", float64(quality.Resolution), opts.Resolution, false,
+		fmt.Sprintf("The photo is only %d pixels across, use a higher resolution", quality.Resolution))
+	if face != nil {
+		check("face_too_small", float64(face.Width), opts.FaceSize, false, "The face is too small, take the photo closer")
+	}
+	return quality
+}
+
+func meanDeviation(pixels []float64) (float64, float64) {
+	var sum, squares float64
+	for _, pixel := range pixels {
+		sum += pixel
+		squares += pixel * pixel
+	}
+	n := float64(len(pixels))
+	mean := sum / n
+	return mean, math.Sqrt(math.Max(squares/n-mean*mean, 0))
+}
+
+// laplacianVariance convolves the pixels with the 4-neighbour Laplacian and
+// returns the variance of the result, a standard measure of focus.
+func laplacianVariance(pixels []float64, width, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+	laplacian := make([]float64, 0, (width-2)*(height-2))
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			laplacian = append(laplacian, pixels[i-width]+pixels[i+width]+pixels[i-1]+pixels[i+1]-4*pixels[i])
+		}
+	}
+	_, deviation := meanDeviation(laplacian)
+	return deviation * deviation
+}
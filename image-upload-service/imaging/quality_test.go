@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+var testQualityOptions = QualityOptions{
+	Sharpness:     Threshold{15, 40},
+	MinBrightness: Threshold{40, 70},
+	MaxBrightness: Threshold{225, 200},
+	Contrast:      Threshold{15, 30},
+	Resolution:    Threshold{240, 480},
+	FaceSize:      Threshold{64, 128},
+}
+
+// flat makes an image of a single gray level.
+func flat(width, height int, level uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = level
+	}
+	return img
+}
+
+// noisy makes a sharp, well exposed image: blocks of random gray levels
+// around the middle of the range, large enough to survive being scaled down
+// for measuring.
+func noisy(width, height int) *image.Gray {
+	const block = 8
+	random := rand.New(rand.NewSource(1))
+	levels := make([]uint8, (width/block+1)*(height/block+1))
+	for i := range levels {
+		levels[i] = uint8(48 + random.Intn(160))
+	}
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Pix[y*img.Stride+x] = levels[(y/block)*(width/block+1)+x/block]
+		}
+	}
+	return img
+}
+
+func issueCodes(quality Quality, severity string) map[string]bool {
+	codes := map[string]bool{}
+	for _, issue := range quality.Issues {
+		if issue.Severity == severity {
+			codes[issue.Code] = true
+		}
+	}
+	return codes
+}
+
+func TestAssessQuality(t *testing.T) {
+	tests := []struct {
+		name       string
+		img        image.Image
+		face       *Face
+		wantReject []string
+		wantWarn   []string
+	}{
+		{"good photo", noisy(800, 600), nil, nil, nil},
+		{"dark and flat", flat(800, 600, 10), nil, []string{"blurry", "too_dark", "low_contrast"}, nil},
+		{"overexposed", flat(800, 600, 250), nil, []string{"blurry", "too_bright", "low_contrast"}, nil},
+		{"dim", flat(800, 600, 60), nil, []string{"blurry", "low_contrast"}, []string{"too_dark"}},
+		{"tiny", noisy(200, 150), nil, []string{"low_resolution"}, nil},
+		{"small", noisy(400, 300), nil, nil, []string{"low_resolution"}},
+		{"small face", noisy(800, 600), &Face{X: 100, Y: 100, Width: 100, Height: 100}, nil, []string{"face_too_small"}},
+		// Scaled up to be measured, so few pixels also look blurry.
+		{"tiny face", noisy(800, 600), &Face{X: 100, Y: 100, Width: 50, Height: 50}, []string{"blurry", "face_too_small"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quality := AssessQuality(test.img, test.face, testQualityOptions)
+			rejected, warned := issueCodes(quality, SeverityReject), issueCodes(quality, SeverityWarn)
+			if len(rejected) != len(test.wantReject) || len(warned) != len(test.wantWarn) {
+				t.Fatalf("AssessQuality() issues = %+v, want rejects %v and warnings %v", quality.Issues, test.wantReject, test.wantWarn)
+			}
+			for _, code := range test.wantReject {
+				if !rejected[code] {
+					t.Errorf("AssessQuality() did not reject for %s: %+v", code, quality.Issues)
+				}
+			}
+			for _, code := range test.wantWarn {
+				if !warned[code] {
+					t.Errorf("AssessQuality() did not warn about %s: %+v", code, quality.Issues)
+				}
+			}
+			if len(quality.Rejected()) != len(test.wantReject) {
+				t.Errorf("Rejected() = %+v, want %d issues", quality.Rejected(), len(test.wantReject))
+			}
+		})
+	}
+}
+
+func TestAssessQualityMeasuresTheFace(t *testing.T) {
+	// A sharp face on a flat background is judged by the face.
+	img := image.NewGray(image.Rect(0, 0, 800, 600))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	face := Face{X: 200, Y: 100, Width: 300, Height: 300}
+	detail := noisy(face.Width, face.Height)
+	for y := 0; y < face.Height; y++ {
+		for x := 0; x < face.Width; x++ {
+			img.SetGray(face.X+x, face.Y+y, color.Gray{Y: detail.GrayAt(x, y).Y})
+		}
+	}
+
+	withFace := AssessQuality(img, &face, testQualityOptions)
+	if len(withFace.Issues) != 0 || withFace.FaceSize != face.Width {
+		t.Errorf("AssessQuality(face) = %+v, want no issues", withFace)
+	}
+	whole := AssessQuality(img, nil, testQualityOptions)
+	if whole.Sharpness >= withFace.Sharpness || whole.Contrast >= withFace.Contrast {
+		t.Errorf("whole image measured %+v, face %+v; the flat background should lower both", whole, withFace)
+	}
+}
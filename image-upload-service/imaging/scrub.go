@@ -0,0 +1,208 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// Metadata categories reported by Scrub.
+const (
+	MetadataEXIF      = "exif"
+	MetadataGPS       = "gps"
+	MetadataThumbnail = "thumbnail"
+	MetadataXMP       = "xmp"
+	MetadataIPTC      = "iptc"
+	MetadataComment   = "comment"
+	MetadataTimestamp = "timestamp"
+	MetadataOther     = "other"
+)
+
+// gpsTag points from the first EXIF IFD to the GPS IFD.
+const gpsTag = 0x8825
+
+// Scrub removes EXIF (with its GPS data and embedded thumbnail), XMP, IPTC,
+// comments and other descriptive metadata from a JPEG, PNG or WebP file
+// without decoding its pixels, and reports the categories it found, sorted.
+// Color profiles and whatever is needed to decode the image are kept. Other
+// formats are returned as they are.
+func Scrub(data []byte, format Format) ([]byte, []string, error) {
+	removed := make(map[string]bool)
+	var scrubbed []byte
+	var err error
+	switch format {
+	case JPEG:
+		scrubbed, err = scrubJPEG(data, removed)
+	case PNG:
+		scrubbed, err = scrubPNG(data, removed)
+	case WebP:
+		scrubbed, err = scrubWebP(data, removed)
+	default:
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	categories := make([]string, 0, len(removed))
+	for category := range removed {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return scrubbed, categories, nil
+}
+
+func scrubJPEG(data []byte, removed map[string]bool) ([]byte, error) {
+	segments := jpegSegments(data)
+	if len(segments) == 0 || segments[len(segments)-1].marker != 0xDA {
+		return nil, ErrInvalidImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	for _, segment := range segments {
+		payload := segment.payload
+		switch {
+		case segment.marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			exifCategories(payload[6:], removed)
+		case segment.marker == 0xE1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/")):
+			removed[MetadataXMP] = true
+		case segment.marker == 0xED:
+			// Photoshop resources, where IPTC lives.
+			removed[MetadataIPTC] = true
+		case segment.marker == 0xE2 && bytes.HasPrefix(payload, []byte("MPF\x00")):
+			// Multi-picture format: preview images.
+			removed[MetadataThumbnail] = true
+		case segment.marker == 0xFE:
+			removed[MetadataComment] = true
+		case segment.marker >= 0xE1 && segment.marker <= 0xEF && !keepJPEGSegment(segment):
+			removed[MetadataOther] = true
+		default:
+			out.Write(data[segment.start:segment.end])
+		}
+	}
+	// Everything after the start of scan is image data.
+	out.Write(data[segments[len(segments)-1].end:])
+	return out.Bytes(), nil
+}
+
+// keepJPEGSegment reports whether an application segment affects how the
+// image is decoded: ICC color profiles and Adobe color transforms.
+func keepJPEGSegment(segment jpegSegment) bool {
+	switch segment.marker {
+	case 0xE2:
+		return bytes.HasPrefix(segment.payload, []byte("ICC_PROFILE\x00"))
+	case 0xEE:
+		return bytes.HasPrefix(segment.payload, []byte("Adobe"))
+	}
+	return false
+}
+
+// pngKept are the ancillary PNG chunks that affect how the image looks.
+var pngKept = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true,
+	"sBIT": true, "bKGD": true, "pHYs": true,
+}
+
+func scrubPNG(data []byte, removed map[string]bool) ([]byte, error) {
+	chunks := pngChunks(data)
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" {
+		return nil, ErrInvalidImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+	for _, chunk := range chunks {
+		critical := chunk.kind[0] >= 'A' && chunk.kind[0] <= 'Z'
+		if critical || pngKept[chunk.kind] {
+			out.Write(data[chunk.start:chunk.end])
+			continue
+		}
+		switch chunk.kind {
+		case "eXIf":
+			exifCategories(chunk.payload, removed)
+		case "tEXt", "zTXt", "iTXt":
+			keyword, _, _ := bytes.Cut(chunk.payload, []byte{0})
+			switch {
+			case string(keyword) == "XML:com.adobe.xmp":
+				removed[MetadataXMP] = true
+			case strings.EqualFold(string(keyword), "Raw profile type iptc"):
+				removed[MetadataIPTC] = true
+			case strings.HasPrefix(strings.ToLower(string(keyword)), "raw profile type exif"):
+				removed[MetadataEXIF] = true
+			default:
+				removed[MetadataComment] = true
+			}
+		case "tIME":
+			removed[MetadataTimestamp] = true
+		default:
+			removed[MetadataOther] = true
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// VP8X flags for the metadata chunks a WebP file holds.
+const (
+	webpEXIFFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+func scrubWebP(data []byte, removed map[string]bool) ([]byte, error) {
+	chunks := webpChunks(data)
+	if len(chunks) == 0 {
+		return nil, ErrInvalidImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "EXIF":
+			exifCategories(bytes.TrimPrefix(chunk.payload, []byte("Exif\x00\x00")), removed)
+		case "XMP ":
+			removed[MetadataXMP] = true
+		case "VP8X":
+			if len(chunk.payload) < 10 {
+				return nil, ErrInvalidImage
+			}
+			header := append([]byte(nil), data[chunk.start:chunk.end]...)
+			header[8] &^= webpEXIFFlag | webpXMPFlag
+			out.Write(header)
+		default:
+			out.Write(data[chunk.start:chunk.end])
+		}
+	}
+
+	// A file that held nothing but metadata is not an image.
+	if out.Len() == 12 {
+		return nil, ErrInvalidImage
+	}
+	scrubbed := out.Bytes()
+	binary.LittleEndian.PutUint32(scrubbed[4:8], uint32(len(scrubbed)-8))
+	return scrubbed, nil
+}
+
+// exifCategories records what an EXIF block held: always EXIF, and GPS and
+// a thumbnail when it has them.
+func exifCategories(tiff []byte, removed map[string]bool) {
+	removed[MetadataEXIF] = true
+	order, offset := tiffHeader(tiff)
+	if order == nil {
+		return
+	}
+	entries := ifdEntries(tiff, order, offset)
+	for _, entry := range entries {
+		if order.Uint16(tiff[entry:]) == gpsTag {
+			removed[MetadataGPS] = true
+		}
+	}
+	// A second IFD describes the embedded thumbnail.
+	if len(entries) > 0 {
+		next := entries[len(entries)-1] + 12
+		if next+4 <= len(tiff) && order.Uint32(tiff[next:]) != 0 {
+			removed[MetadataThumbnail] = true
+		}
+	}
+}
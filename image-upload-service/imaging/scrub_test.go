@@ -0,0 +1,221 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// tiffWithGPSAndThumbnail builds an EXIF TIFF block whose first IFD points
+// to GPS data and is followed by a second IFD, which describes a thumbnail.
+func tiffWithGPSAndThumbnail(order binary.ByteOrder) []byte {
+	tiff := tiffWithOrientation(order, 1)
+	// Room for a second entry, then the next IFD offset and an empty IFD.
+	tiff = append(tiff[:10+12], make([]byte, 12+4+6)...)
+	order.PutUint16(tiff[8:], 2)
+	entry := tiff[22:]
+	order.PutUint16(entry[0:], gpsTag)
+	order.PutUint16(entry[2:], 4) // LONG
+	order.PutUint32(entry[4:], 1)
+	order.PutUint32(entry[8:], uint32(len(tiff)-6))
+	order.PutUint32(tiff[34:], uint32(len(tiff)-6))
+	return tiff
+}
+
+// jpegWithSegments builds a JPEG from application segments, each a marker
+// and its payload, ending at the start of scan.
+func jpegWithSegments(segments ...jpegTestSegment) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, segment := range segments {
+		data = append(data, 0xFF, segment.marker)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(segment.payload)+2))
+		data = append(data, segment.payload...)
+	}
+	return append(data, 0xFF, 0xDA, 0x00, 0x02, 0x00)
+}
+
+type jpegTestSegment struct {
+	marker  byte
+	payload string
+}
+
+// webpICCFlag is the VP8X flag for a color profile, which Scrub keeps.
+const webpICCFlag = 0x20
+
+func TestScrubRejectsShortVP8X(t *testing.T) {
+	data := []byte("RIFF\x0c\x00\x00\x00WEBPVP8X\x00\x00\x00\x00")
+	if _, _, err := Scrub(data, WebP); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Scrub() error = %v, want ErrInvalidImage", err)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	tiff := tiffWithOrientation(binary.LittleEndian, 6)
+	located := tiffWithGPSAndThumbnail(binary.BigEndian)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	png = appendPNGChunk(png, "IHDR", make([]byte, 13))
+	png = appendPNGChunk(png, "iCCP", []byte("icc\x00\x00profile"))
+	png = appendPNGChunk(png, "eXIf", located)
+	png = appendPNGChunk(png, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+	png = appendPNGChunk(png, "zTXt", []byte("Raw profile type iptc\x00\x00iptc"))
+	png = appendPNGChunk(png, "tEXt", []byte("Comment\x00taken at home"))
+	png = appendPNGChunk(png, "tIME", []byte{0x07, 0xEA, 10, 18, 12, 0, 0})
+	png = appendPNGChunk(png, "IDAT", []byte("pixels"))
+	png = appendPNGChunk(png, "IEND", nil)
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	webp = appendWebPChunk(webp, "VP8X", []byte{webpEXIFFlag | webpXMPFlag | webpICCFlag, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	webp = appendWebPChunk(webp, "ICCP", []byte("profile"))
+	webp = appendWebPChunk(webp, "VP8 ", []byte("pixels"))
+	webp = appendWebPChunk(webp, "EXIF", append([]byte("Exif\x00\x00"), located...))
+	webp = appendWebPChunk(webp, "XMP ", []byte("<x:xmpmeta/>"))
+	binary.LittleEndian.PutUint32(webp[4:], uint32(len(webp)-8))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+		want   []string
+		gone   []string
+		kept   []string
+	}{
+		{
+			name:   "jpeg exif",
+			data:   jpegWithEXIF(tiff),
+			format: JPEG,
+			want:   []string{MetadataEXIF},
+			gone:   []string{"Exif\x00\x00"},
+		},
+		{
+			name: "jpeg with everything",
+			data: jpegWithSegments(
+				jpegTestSegment{0xE0, "JFIF\x00\x01\x01"},
+				jpegTestSegment{0xE1, "Exif\x00\x00" + string(located)},
+				jpegTestSegment{0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"},
+				jpegTestSegment{0xED, "Photoshop 3.0\x008BIM\x04\x04"},
+				jpegTestSegment{0xE2, "ICC_PROFILE\x00\x01\x01profile"},
+				jpegTestSegment{0xE2, "MPF\x00preview"},
+				jpegTestSegment{0xFE, "taken at home"},
+				jpegTestSegment{0xE5, "maker notes"},
+			),
+			format: JPEG,
+			want: []string{MetadataComment, MetadataEXIF, MetadataGPS, MetadataIPTC,
+				MetadataOther, MetadataThumbnail, MetadataXMP},
+			gone: []string{"Exif\x00\x00", "<x:xmpmeta/>", "Photoshop 3.0", "MPF\x00", "taken at home", "maker notes"},
+			kept: []string{"JFIF\x00", "ICC_PROFILE\x00"},
+		},
+		{
+			name:   "png exif",
+			data:   pngWithEXIF(tiff),
+			format: PNG,
+			want:   []string{MetadataEXIF},
+			gone:   []string{"eXIf"},
+			kept:   []string{"IHDR", "IEND"},
+		},
+		{
+			name:   "png with everything",
+			data:   png,
+			format: PNG,
+			want: []string{MetadataComment, MetadataEXIF, MetadataGPS, MetadataIPTC,
+				MetadataThumbnail, MetadataTimestamp, MetadataXMP},
+			gone: []string{"eXIf", "<x:xmpmeta/>", "Raw profile type iptc", "taken at home", "tIME"},
+			kept: []string{"IHDR", "iCCP", "IDAT", "IEND"},
+		},
+		{
+			name:   "webp exif",
+			data:   webpWithEXIF(tiff),
+			format: WebP,
+			want:   []string{MetadataEXIF},
+			gone:   []string{"EXIF"},
+			kept:   []string{"VP8X"},
+		},
+		{
+			name:   "webp with everything",
+			data:   webp,
+			format: WebP,
+			want:   []string{MetadataEXIF, MetadataGPS, MetadataThumbnail, MetadataXMP},
+			gone:   []string{"EXIF", "Exif\x00\x00", "XMP ", "<x:xmpmeta/>"},
+			kept:   []string{"VP8X", "ICCP", "VP8 "},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scrubbed, removed, err := Scrub(test.data, test.format)
+			if err != nil {
+				t.Fatalf("Scrub() error = %v", err)
+			}
+			if !reflect.DeepEqual(removed, test.want) {
+				t.Errorf("Scrub() removed %v, want %v", removed, test.want)
+			}
+			for _, gone := range test.gone {
+				if bytes.Contains(scrubbed, []byte(gone)) {
+					t.Errorf("scrubbed file still holds %q", gone)
+				}
+			}
+			for _, kept := range test.kept {
+				if !bytes.Contains(scrubbed, []byte(kept)) {
+					t.Errorf("scrubbed file lost %q", kept)
+				}
+			}
+			if format, err := Sniff(scrubbed); err != nil || format != test.format {
+				t.Errorf("Sniff(scrubbed) = %q, %v, want %q", format, err, test.format)
+			}
+		})
+	}
+}
+
+func TestScrubWebPHeader(t *testing.T) {
+	data := webpWithEXIF(tiffWithOrientation(binary.LittleEndian, 1))
+	data[20] |= webpXMPFlag | webpICCFlag
+	scrubbed, _, err := Scrub(data, WebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The metadata flags go with their chunks; the color profile flag stays.
+	if flags := scrubbed[20]; flags != webpICCFlag {
+		t.Errorf("VP8X flags = %#x, want %#x", flags, webpICCFlag)
+	}
+	if size := binary.LittleEndian.Uint32(scrubbed[4:]); int(size) != len(scrubbed)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(scrubbed)-8)
+	}
+}
+
+func TestScrubLeavesGIF(t *testing.T) {
+	data := []byte("GIF89a\x01\x00\x01\x00")
+	scrubbed, removed, err := Scrub(data, GIF)
+	if err != nil || len(removed) != 0 || !bytes.Equal(scrubbed, data) {
+		t.Errorf("Scrub(gif) = %q, %v, %v, want it unchanged", scrubbed, removed, err)
+	}
+}
+
+// FuzzScrub feeds Scrub and Orientation arbitrary files, which come straight
+// from clients. Neither may panic, and scrubbing what Scrub returned finds
+// nothing more to remove.
+func FuzzScrub(f *testing.F) {
+	tiff := tiffWithOrientation(binary.LittleEndian, 6)
+	f.Add(jpegWithEXIF(tiff))
+	f.Add(pngWithEXIF(tiff))
+	f.Add(webpWithEXIF(tiff))
+	f.Add([]byte("RIFF\x0c\x00\x00\x00WEBPVP8X\x00\x00\x00\x00"))
+	f.Add([]byte("GIF89a"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		format, err := Sniff(data)
+		if err != nil {
+			return
+		}
+		if orientation := Orientation(data, format); orientation < 1 || orientation > 8 {
+			t.Fatalf("Orientation() = %d", orientation)
+		}
+		scrubbed, _, err := Scrub(data, format)
+		if err != nil {
+			return
+		}
+		if _, removed, err := Scrub(scrubbed, format); err != nil || len(removed) != 0 {
+			t.Fatalf("scrubbing again removed %v, error %v", removed, err)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Orient turns an image the way its EXIF orientation says, so it is upright
+// with no orientation left to apply.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// Orientations 5 to 8 swap the axes.
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width
+	}
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var outX, outY int
+			switch orientation {
+			case 2: // mirrored
+				outX, outY = width-1-x, y
+			case 3: // rotated 180
+				outX, outY = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				outX, outY = x, height-1-y
+			case 5: // mirrored and rotated 270 clockwise
+				outX, outY = y, x
+			case 6: // rotated 90 clockwise
+				outX, outY = height-1-y, x
+			case 7: // mirrored and rotated 90 clockwise
+				outX, outY = height-1-y, width-1-x
+			case 8: // rotated 270 clockwise
+				outX, outY = y, width-1-x
+			}
+			out.Set(outX, outY, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// Fit scales an image down so neither side is longer than maxDimension,
+// keeping its aspect ratio. Smaller images are returned as they are.
+func Fit(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(out, out.Bounds(), img, bounds, xdraw.Src, nil)
+	return out
+}
+
+// flatten draws an image over white, since JPEG has no transparency.
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Over)
+	return out
+}
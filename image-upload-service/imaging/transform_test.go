@@ -0,0 +1,110 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// labelled makes an image whose pixels are told apart by their red value,
+// laid out row by row as in rows.
+func labelled(rows [][]uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, label := range row {
+			img.Set(x, y, color.RGBA{R: label, A: 255})
+		}
+	}
+	return img
+}
+
+func labels(img image.Image) [][]uint8 {
+	bounds := img.Bounds()
+	rows := make([][]uint8, bounds.Dy())
+	for y := range rows {
+		rows[y] = make([]uint8, bounds.Dx())
+		for x := range rows[y] {
+			r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rows[y][x] = uint8(r >> 8)
+		}
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image as stored by the camera:
+	//
+	//	1 2 3
+	//	4 5 6
+	stored := [][]uint8{{1, 2, 3}, {4, 5, 6}}
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{0, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+		{9, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+	}
+	for _, test := range tests {
+		got := labels(Orient(labelled(stored), test.orientation))
+		if !equalLabels(got, test.want) {
+			t.Errorf("Orient(%d) = %v, want %v", test.orientation, got, test.want)
+		}
+	}
+}
+
+func TestOrientSubImage(t *testing.T) {
+	// Sub-images do not start at the origin.
+	full := labelled([][]uint8{{9, 9, 9}, {9, 1, 2}, {9, 3, 4}})
+	got := labels(Orient(full.SubImage(image.Rect(1, 1, 3, 3)), 6))
+	if want := [][]uint8{{3, 1}, {4, 2}}; !equalLabels(got, want) {
+		t.Errorf("Orient(sub-image, 6) = %v, want %v", got, want)
+	}
+}
+
+func equalLabels(a, b [][]uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if string(a[y]) != string(b[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height, max    int
+		wantWidth, wantHeight int
+	}{
+		{"landscape", 4000, 3000, 1000, 1000, 750},
+		{"portrait", 3000, 4000, 1000, 750, 1000},
+		{"square", 2000, 2000, 1000, 1000, 1000},
+		{"wide sliver", 5000, 2, 1000, 1000, 1},
+		{"tall sliver", 2, 5000, 1000, 1, 1000},
+		{"odd ratio", 1001, 333, 1000, 1000, 332},
+		{"already small", 800, 600, 1000, 800, 600},
+		{"exactly the limit", 1000, 400, 1000, 1000, 400},
+		{"no limit", 4000, 3000, 0, 4000, 3000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+			bounds := Fit(img, test.max).Bounds()
+			if bounds.Dx() != test.wantWidth || bounds.Dy() != test.wantHeight {
+				t.Errorf("Fit(%dx%d, %d) = %dx%d, want %dx%d", test.width, test.height, test.max,
+					bounds.Dx(), bounds.Dy(), test.wantWidth, test.wantHeight)
+			}
+		})
+	}
+}
@@ -2,26 +2,48 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image-upload-service/apierror"
+	"image-upload-service/controllers"
+	"image-upload-service/imaging"
+	"image-upload-service/models"
 	"image-upload-service/utils"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"github.com/IBM/sarama"
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 )
 
+// ImageRequest asks the image processing service to score an image. ImageUrl
+// is a short-lived signed URL for the face crop; ImageKey, the stored
+// original, is echoed back in the response so the score can be stored
+// against the object rather than the URL.
 type ImageRequest struct {
 	ImageUrl string `json:"image_url"`
+	ImageKey string `json:"image_key"`
 	UserId  string `json:"user_id"`
+	JobId   string `json:"job_id"`
 }
 
+// ImageDataStore is an image as kept in the images collection, whose document
+// is created when the upload is picked up and has the job's ID. The scores
+// are set once Status is scored. Images are private, so only the object key
+// is stored.
 type ImageDataStore struct {
-	ImageURL 			  string  `json:"image_url"`
+	ImageKey 			  string  `json:"image_key"`
 	UserId				  string  `json:"user_id"`
 	TotalScore			  float32 `json:"total_score"`	
 	Symmetry              float64 `json:"symmetry"`
@@ -35,23 +57,72 @@ type ImageDataStore struct {
 	LipFullness           float64 `json:"lip_fullness"`
 	FacialFat             float64 `json:"facial_fat"`
 	CompleteFacialHarmony float64 `json:"complete_facial_harmony"`			
+	// Renditions are the resized copies stored next to the image.
+	Renditions map[string]models.Rendition `json:"renditions,omitempty"`
+	// MetadataRemoved lists the metadata categories, such as exif and gps,
+	// scrubbed from the upload before it was stored.
+	MetadataRemoved []string `json:"metadata_removed"`
+	// Face is the bounding box of the face in the stored image.
+	Face *models.Face `json:"face,omitempty"`
+	// Quality holds the quality measurements and any warnings about them.
+	Quality     *models.Quality `json:"quality,omitempty"`
+	ContentHash string          `json:"content_hash,omitempty"`
+	// Fingerprint holds the image's perceptual hashes. An image that looked
+	// like another user's or a blocklisted one carries the matches in
+	// Flags and a pending Review, and stays off the leaderboards until a
+	// moderator approves it.
+	Fingerprint *models.Fingerprint `json:"-"`
+	Flags       []models.FlagMatch  `json:"-"`
+	Review      string              `json:"-"`
+	// The lifecycle fields are left out of the score breakdown kept on jobs.
+	// Timestamps holds when the image reached each status, and Error and
+	// ErrorCode why a rejected or failed image was not scored.
+	JobId      string             `json:"-"`
+	Filename   string             `json:"-"`
+	Status     models.ImageStatus `json:"-"`
+	Timestamps map[string]int64   `json:"-"`
+	Error      string             `json:"-"`
+	ErrorCode  string             `json:"-"`
+	CreatedAt  int64              `json:"-"`
+	UpdatedAt  int64              `json:"-"`
 }
 
+// imagingOptions controls how uploads are normalized, faceOptions which faces
+// are accepted, qualityOptions how poor a photo may be, cropOptions how faces
+// are cut out for scoring, matchDistance how alike two images must look to be
+// flagged, and planLimits how many scans each plan allows. They are read from
+// the environment at startup.
+var (
+	imagingOptions imaging.Options
+	faceOptions    imaging.FaceOptions
+	qualityOptions imaging.QualityOptions
+	cropOptions    imaging.CropOptions
+	matchDistance  int
+	planLimits     controllers.Plans
+)
+
+// faceRendition names the face crop that is sent for scoring.
+const faceRendition = "face"
+
 type ImageResponse struct {
-	ImageURL              string  `json:"image_url"`
-	UserId                string  `json:"user_id"`
-	TotalScore            float32 `json:"total_score"`
-	Symmetry              float64 `json:"symmetry"`
-	FacialDefinition      float64 `json:"facial_definition"`
-	Jawline               float64 `json:"jawline"`
-	Cheekbones            float64 `json:"cheekbones"`
-	JawlineToCheekbones   float64 `json:"jawline_to_cheekbones"`
-	CanthalTilt           float64 `json:"canthal_tilt"`
-	ProportionAndRatios   float64 `json:"proportion_and_ratios"`
-	SkinQuality           float64 `json:"skin_quality"`
-	LipFullness           float64 `json:"lip_fullness"`
-	FacialFat             float64 `json:"facial_fat"`
-	CompleteFacialHarmony float64 `json:"complete_facial_harmony"`
+	ImageURL              string          `json:"image_url"`
+	ImageKey              string          `json:"image_key"`
+	UserId                string          `json:"user_id"`
+	JobId                 string          `json:"job_id,omitempty"`
+	StatusCode            int             `json:"statusCode,omitempty"`
+	Error                 *apierror.Error `json:"error,omitempty"`
+	TotalScore            float32         `json:"total_score"`
+	Symmetry              float64         `json:"symmetry"`
+	FacialDefinition      float64         `json:"facial_definition"`
+	Jawline               float64         `json:"jawline"`
+	Cheekbones            float64         `json:"cheekbones"`
+	JawlineToCheekbones   float64         `json:"jawline_to_cheekbones"`
+	CanthalTilt           float64         `json:"canthal_tilt"`
+	ProportionAndRatios   float64         `json:"proportion_and_ratios"`
+	SkinQuality           float64         `json:"skin_quality"`
+	LipFullness           float64         `json:"lip_fullness"`
+	FacialFat             float64         `json:"facial_fat"`
+	CompleteFacialHarmony float64         `json:"complete_facial_harmony"`
 }
 
 func main() {
@@ -62,15 +133,45 @@ func main() {
 
 	app := fiber.New()
 
+	imagingOptions = imaging.OptionsFromEnv()
+	faceOptions = imaging.FaceOptionsFromEnv()
+	qualityOptions = imaging.QualityOptionsFromEnv()
+	cropOptions = imaging.CropOptionsFromEnv()
+	matchDistance = controllers.MatchDistanceFromEnv()
+	planLimits = controllers.PlansFromEnv()
+
 	utils.InitFirebase()
 	defer utils.CloseFirestore()
 
-	go startKafkaConsumer()
+	utils.InitProducer()
+	defer utils.CloseProducer()
+
+	ctx, stopConsumer := context.WithCancel(context.Background())
+	consumerDone := startKafkaConsumer(ctx)
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":8082"); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
 
-	log.Fatal(app.Listen(":8082"))
+	// Stop consuming before the deferred producer close, so that no handler
+	// sends on a closed producer.
+	stopConsumer()
+	<-consumerDone
 }
 
-func startKafkaConsumer() {
+// startKafkaConsumer consumes until ctx is cancelled. The returned channel is
+// closed once the handlers have finished their last messages and the
+// consumer group is closed.
+func startKafkaConsumer(ctx context.Context) <-chan struct{} {
 	brokers := []string{"kafka:9092"}
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
@@ -83,13 +184,18 @@ func startKafkaConsumer() {
 
 	handler := ConsumerGroupHandler{}
 
-	for {
-		err := consumer.Consume(context.Background(), []string{"image-upload"}, handler)
-		if err != nil {
-			log.Printf("Error from consumer: %v", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer consumer.Close()
+		for ctx.Err() == nil {
+			err := consumer.Consume(ctx, []string{"image-upload", "image-processing-response", "quota-check"}, handler)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error from consumer: %v", err)
+			}
 		}
-	}
-
+	}()
+	return done
 }
 
 
@@ -104,6 +210,8 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 			processImageUpload(msg)
 		case "image-processing-response":
 			processImageProcessingResponse(msg)
+		case "quota-check":
+			processQuotaCheck(msg)
 		}
 		sess.MarkMessage(msg, "")
 	}
@@ -114,71 +222,208 @@ func processImageUpload(msg *sarama.ConsumerMessage) {
 	bucketName := os.Getenv("BUCKET_NAME")
 	bucket := utils.StorageClient.Bucket(bucketName)
 
-	// Extract metadata from headers
-	var filename, contentType, userID string
-	for _, header := range msg.Headers {
-		switch string(header.Key) {
-		case "filename":
-			filename = string(header.Value)
-		case "contentType":
-			contentType = string(header.Value)
-		case "userID":
-			userID = string(header.Value)
-		}
+	var upload models.ImageUploadMessage
+	if err := json.Unmarshal(msg.Value, &upload); err != nil {
+		log.Printf("Error unmarshalling image upload: %v", err)
+		return
+	}
+	jobID, userID := upload.JobId, upload.UserId
+	// A message delivered again, after a crash or a rebalance, must not be
+	// charged or scored twice, so only an upload that got no further than
+	// being picked up is processed again. A job that has ended may have
+	// discarded its image, so the job is checked first.
+	if job, err := controllers.GetJob(jobID); err != nil {
+		log.Printf("Error getting job %s: %v", jobID, err)
+	} else if job != nil && job.Status.Final() {
+		log.Printf("Job %s has already ended as %s, skipping its upload", jobID, job.Status)
+		return
+	}
+
+	// The image is tracked from here on, under the job's ID, whether or not
+	// it ends up scored.
+	imageStatus, err := controllers.CreateImage(jobID, userID, upload.Filename)
+	if err != nil {
+		log.Printf("Error creating image for job %s: %v", jobID, err)
+	} else if imageStatus != models.ImageUploaded {
+		log.Printf("Image for job %s is already %s, skipping its upload", jobID, imageStatus)
+		return
+	}
+
+	// The message is a claim check: fetch the image the gateway staged and
+	// make sure it is the one the message describes.
+	staged := utils.StorageClient.Bucket(upload.Bucket).Object(upload.Object)
+	defer deleteStagedImage(staged)
+	data, err := readStagedImage(staged, upload)
+	if err != nil {
+		log.Printf("Error resolving staged image %s/%s: %v", upload.Bucket, upload.Object, err)
+		controllers.FailJob(jobID, userID, "Error storing image")
+		return
+	}
+
+	// Whatever the client sent becomes an upright, size capped JPEG with
+	// thumbnail and medium renditions, with its metadata scrubbed before
+	// any of it is stored with the user's images.
+	normalized, err := imaging.Normalize(data, imagingOptions)
+	if err != nil {
+		log.Printf("Error normalizing image for job %s: %v", jobID, err)
+		rejectImage(jobID, userID, err)
+		return
 	}
-	// Create a new object in the bucket
-	fileName := fmt.Sprintf("images/%d_%s", time.Now().Unix(), filename)
-	object := bucket.Object(fileName)
-	writer := object.NewWriter(context.Background())
-	writer.ContentType = contentType
 
-	// Write the file data to the object
-	if _, err := writer.Write(msg.Value); err != nil {
-		log.Printf("Error writing file to GCS: %v", err)
+	contentHash := controllers.ContentHash(normalized.Original.Data)
+	if err := controllers.MoveImage(jobID, models.ImageNormalized,
+		firestore.Update{Path: "MetadataRemoved", Value: normalized.MetadataRemoved},
+		firestore.Update{Path: "ContentHash", Value: contentHash},
+	); errors.Is(err, controllers.ErrImageTransition) {
+		// Another delivery of the message got here first.
+		log.Printf("Image for job %s is already past uploaded, skipping its upload: %v", jobID, err)
 		return
+	} else if err != nil {
+		log.Printf("Error moving image for job %s to normalized: %v", jobID, err)
 	}
-	if err := writer.Close(); err != nil {
-		log.Printf("Error closing writer: %v", err)	
+
+	// A user sending the same photo again gets the score it already has,
+	// without another scoring call.
+	if scored, err := controllers.FindScoredImage(userID, contentHash); err != nil {
+		log.Printf("Error looking up image hash for job %s, scoring it: %v", jobID, err)
+	} else if scored != nil {
+		completeDuplicate(jobID, userID, contentHash, scored)
 		return
 	}
 
-	if err := object.ACL().Set(context.Background(), storage.AllUsers, storage.RoleReader); err != nil {
-		log.Printf("Error setting object ACL: %v", err)
+	// Scoring is paid for, so only clear photos of one face that is large
+	// enough are sent to it. Poor quality is reported ahead of a missing
+	// face, as a dark or blurry photo is often why no face was found.
+	face, faceErr := imaging.CheckFace(normalized.Image, faceOptions)
+	var found *imaging.Face
+	if faceErr == nil {
+		found = &face
+	}
+	quality := imaging.AssessQuality(normalized.Image, found, qualityOptions)
+	qualityUpdate := firestore.Update{Path: "Quality", Value: qualityRecord(quality)}
+	if rejected := quality.Rejected(); len(rejected) > 0 {
+		log.Printf("Quality check turned down image for job %s: %+v", jobID, rejected)
+		controllers.RejectJob(jobID, userID, rejected[0].Code, rejected[0].Message, qualityUpdate)
+		return
+	}
+	if faceErr != nil {
+		log.Printf("Face check turned down image for job %s: %v", jobID, faceErr)
+		rejectImage(jobID, userID, faceErr, qualityUpdate)
 		return
 	}
 
-	publicUrl := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, fileName)
-	log.Printf("Image uploaded successfully: %s", publicUrl)
+	// The scoring provider gets only the face, padded and turned level, as
+	// a rendition of its own; the rest of the photo is not shared.
+	faceCrop, err := imaging.CropFace(normalized.Image, face, cropOptions)
+	if err != nil {
+		log.Printf("Error cropping face for job %s: %v", jobID, err)
+		controllers.FailJob(jobID, userID, "Error processing image")
+		return
+	}
+	normalized.Renditions[faceRendition] = faceCrop
 
+	// Images that look like another user's or a blocklisted one are still
+	// scored, but flagged for review.
+	fingerprint := controllers.Fingerprint(normalized.Image)
+	flags, err := controllers.FindMatches(userID, fingerprint, matchDistance)
+	if err != nil {
+		log.Printf("Error matching image for job %s, scoring it unchecked: %v", jobID, err)
+	}
+	if len(flags) > 0 {
+		log.Printf("Job %s looks like %d known images, flagging it for review", jobID, len(flags))
+	}
+
+	// Only images that will be scored count against the user's quota. A
+	// scan that then fails before it is scored is given back.
+	chargedAt := time.Now()
+	if err := controllers.ChargeScan(userID, planLimits, chargedAt); err != nil {
+		if exceeded, ok := controllers.IsQuotaExceeded(err); ok {
+			log.Printf("User %s is out of scans, turning down job %s: %v", userID, jobID, exceeded)
+			controllers.RejectJob(jobID, userID, "quota_exceeded", fmt.Sprintf(
+				"You have used the %d scans your plan allows %s, more are available from %s",
+				exceeded.Limit, windowNoun[exceeded.Window], exceeded.ResetsAt.Format(time.RFC1123)))
+			return
+		}
+		log.Printf("Error charging scan for job %s: %v", jobID, err)
+		controllers.FailJob(jobID, userID, "Error checking scan quota")
+		return
+	}
+	failCharged := func(reason string) {
+		controllers.FailChargedJob(jobID, userID, reason, chargedAt)
+	}
+
+	fileName, renditions, err := controllers.StoreImage(context.Background(), bucket, imageFolder(jobID), normalized)
+	if err != nil {
+		log.Printf("Error writing image to GCS: %v", err)
+		failCharged("Error storing image")
+		return
+	}
+	faceRecord := models.Face{X: face.X, Y: face.Y, Width: face.Width, Height: face.Height, Score: float64(face.Score)}
+	if err := controllers.UpdateImage(jobID,
+		firestore.Update{Path: "ImageKey", Value: fileName},
+		firestore.Update{Path: "Renditions", Value: renditions},
+		firestore.Update{Path: "Face", Value: faceRecord},
+		qualityUpdate,
+	); err != nil {
+		log.Printf("Error recording stored image for job %s: %v", jobID, err)
+	}
+
+	// The objects stay private; the scoring service gets a signed URL for
+	// the face crop.
+	log.Printf("Image uploaded successfully: %s/%s (%s, %dx%d)", bucketName, fileName,
+		normalized.SourceFormat, normalized.Original.Width, normalized.Original.Height)
+	controllers.UpdateJobStatus(jobID, userID, models.JobStored,
+		firestore.Update{Path: "ImageKey", Value: fileName},
+		firestore.Update{Path: "Renditions", Value: renditions},
+		firestore.Update{Path: "MetadataRemoved", Value: normalized.MetadataRemoved},
+		firestore.Update{Path: "Face", Value: faceRecord},
+		qualityUpdate,
+		firestore.Update{Path: "ContentHash", Value: contentHash},
+		firestore.Update{Path: "Fingerprint", Value: fingerprint},
+		firestore.Update{Path: "Flags", Value: flags},
+		firestore.Update{Path: "UnderReview", Value: len(flags) > 0},
+		firestore.Update{Path: "QuotaChargedAt", Value: chargedAt.Unix()},
+	)
+
+	signedUrl, err := utils.SignedImageURL(renditions[faceRendition].Key)
+	if err != nil {
+		log.Printf("Error signing image URL: %v", err)
+		failCharged("Error requesting image scoring")
+		return
+	}
 
 	// Create a message for the Image Processing Service
 	imageRequest := ImageRequest{
-		ImageUrl: publicUrl,
+		ImageUrl: signedUrl,
+		ImageKey: fileName,
 		UserId: userID,
+		JobId: jobID,
 	}
 
 	jsonData, err := json.Marshal(imageRequest)
 	if err != nil {
 		log.Printf("Error marshalling image request: %v", err)
+		failCharged("Error requesting image scoring")
 		return
 	}
 
+	// The job and image move to scoring before the request goes out, so that
+	// a fast response always finds them there.
+	controllers.UpdateJobStatus(jobID, userID, models.JobScoring)
+	if err := controllers.MoveImage(jobID, models.ImageScoring); err != nil {
+		log.Printf("Error moving image for job %s to scoring: %v", jobID, err)
+	}
+
 	kafkaMessage := &sarama.ProducerMessage{
 		Topic: "image-processing",
 		Value: sarama.ByteEncoder(jsonData),
 	}
 
-	producer, err := sarama.NewSyncProducer([]string{"kafka:9092"}, nil)
-	if err != nil {
-		log.Printf("Error creating producer: %v", err)
-		return
-	}
-
-	defer producer.Close()
-
-	_, _, err = producer.SendMessage(kafkaMessage)
+	err = utils.KafkaProducer.SendMessage(kafkaMessage)
 	if err != nil {
 		log.Printf("Error producing message: %v", err)
+		failCharged("Error requesting image scoring")
+		return
 	}
 }
 
@@ -189,9 +434,20 @@ func processImageProcessingResponse(msg *sarama.ConsumerMessage) {
 		return
 	}
 
+	if imageResponse.Error != nil || (imageResponse.StatusCode != 0 && imageResponse.StatusCode != http.StatusOK) {
+		reason := "Image scoring failed"
+		if imageResponse.Error != nil {
+			reason = imageResponse.Error.Message
+		}
+		log.Printf("Image scoring failed for user %s: %s", imageResponse.UserId, reason)
+		// The scan is given back only if this reply is what ends the job.
+		controllers.FailChargedJob(imageResponse.JobId, imageResponse.UserId, reason, time.Time{})
+		return
+	}
+
 	// Save the complete ImageDataStore to Firestore
 	imageData := ImageDataStore{
-		ImageURL:              imageResponse.ImageURL,
+		ImageKey:              imageResponse.ImageKey,
 		UserId:                imageResponse.UserId,
 		TotalScore:            imageResponse.TotalScore,
 		Symmetry:              imageResponse.Symmetry,
@@ -206,11 +462,263 @@ func processImageProcessingResponse(msg *sarama.ConsumerMessage) {
 		FacialFat:             imageResponse.FacialFat,
 		CompleteFacialHarmony: imageResponse.CompleteFacialHarmony,
 	}
+	if job, err := controllers.GetJob(imageResponse.JobId); err != nil {
+		log.Printf("Error getting job %s: %v", imageResponse.JobId, err)
+	} else if job != nil {
+		imageData.Renditions = job.Renditions
+		imageData.MetadataRemoved = job.MetadataRemoved
+		imageData.Face = job.Face
+		imageData.Quality = job.Quality
+		imageData.ContentHash = job.ContentHash
+		imageData.Fingerprint = job.Fingerprint
+		if len(job.Flags) > 0 {
+			imageData.Flags = job.Flags
+			imageData.Review = models.ReviewPending
+		}
+	}
+
+	imageID, err := saveScoredImage(imageResponse.JobId, imageData)
+	if errors.Is(err, controllers.ErrImageTransition) {
+		log.Printf("Ignoring response for job %s: %v", imageResponse.JobId, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving score of job %s: %v", imageResponse.JobId, err)
+		controllers.FailJob(imageResponse.JobId, imageResponse.UserId, "Error saving score")
+		return
+	}
+	if imageData.ContentHash != "" {
+		if err := controllers.RecordImageHash(imageData.UserId, imageData.ContentHash, imageID); err != nil {
+			log.Printf("Error recording hash of image %s: %v", imageID, err)
+		}
+	}
+
+	controllers.UpdateJobStatus(imageResponse.JobId, imageResponse.UserId, models.JobScored,
+		firestore.Update{Path: "ImageId", Value: imageID},
+		firestore.Update{Path: "Result", Value: scoreResult(imageData)},
+	)
+}
+
+// saveScoredImage moves the job's image to scored with its scores and returns
+// its ID. Uploads without a job, and jobs started before images were tracked
+// from upload, have no image document yet; theirs is written here.
+func saveScoredImage(jobID string, imageData ImageDataStore) (string, error) {
+	now := time.Now().Unix()
+	imageData.JobId = jobID
+	imageData.Status = models.ImageScored
+	imageData.Timestamps = map[string]int64{string(models.ImageScored): now}
+	imageData.CreatedAt, imageData.UpdatedAt = now, now
+	if jobID == "" {
+		imageDoc, _, err := utils.FirestoreClient.Collection("images").Add(context.Background(), imageData)
+		if err != nil {
+			return "", err
+		}
+		return imageDoc.ID, nil
+	}
+
+	err := controllers.MoveImage(jobID, models.ImageScored,
+		firestore.Update{Path: "TotalScore", Value: imageData.TotalScore},
+		firestore.Update{Path: "Symmetry", Value: imageData.Symmetry},
+		firestore.Update{Path: "FacialDefinition", Value: imageData.FacialDefinition},
+		firestore.Update{Path: "Jawline", Value: imageData.Jawline},
+		firestore.Update{Path: "Cheekbones", Value: imageData.Cheekbones},
+		firestore.Update{Path: "JawlineToCheekbones", Value: imageData.JawlineToCheekbones},
+		firestore.Update{Path: "CanthalTilt", Value: imageData.CanthalTilt},
+		firestore.Update{Path: "ProportionAndRatios", Value: imageData.ProportionAndRatios},
+		firestore.Update{Path: "SkinQuality", Value: imageData.SkinQuality},
+		firestore.Update{Path: "LipFullness", Value: imageData.LipFullness},
+		firestore.Update{Path: "FacialFat", Value: imageData.FacialFat},
+		firestore.Update{Path: "CompleteFacialHarmony", Value: imageData.CompleteFacialHarmony},
+		firestore.Update{Path: "Fingerprint", Value: imageData.Fingerprint},
+		firestore.Update{Path: "Flags", Value: imageData.Flags},
+		firestore.Update{Path: "Review", Value: imageData.Review},
+	)
+	if errors.Is(err, controllers.ErrImageNotFound) {
+		_, err = utils.FirestoreClient.Collection("images").Doc(jobID).Set(context.Background(), imageData)
+	}
+	return jobID, err
+}
+
+// completeDuplicate finishes a job whose image the user already had scored,
+// reusing that image and its score.
+func completeDuplicate(jobID, userID, contentHash string, scored *firestore.DocumentSnapshot) {
+	var imageData ImageDataStore
+	if err := scored.DataTo(&imageData); err != nil {
+		log.Printf("Error unmarshalling image %s: %v", scored.Ref.ID, err)
+		controllers.FailJob(jobID, userID, "Error reusing score")
+		return
+	}
+	log.Printf("Job %s repeats image %s, reusing its score", jobID, scored.Ref.ID)
+	controllers.UpdateJobStatus(jobID, userID, models.JobScored,
+		firestore.Update{Path: "ImageId", Value: scored.Ref.ID},
+		firestore.Update{Path: "ImageKey", Value: imageData.ImageKey},
+		firestore.Update{Path: "Renditions", Value: imageData.Renditions},
+		firestore.Update{Path: "ContentHash", Value: contentHash},
+		firestore.Update{Path: "Duplicate", Value: true},
+		firestore.Update{Path: "Result", Value: scoreResult(imageData)},
+	)
+	// The job points at the earlier image, so this upload needs no image of
+	// its own.
+	if err := controllers.DiscardImage(jobID); err != nil {
+		log.Printf("Error discarding image of job %s: %v", jobID, err)
+	}
+}
+
+// scoreResult is the breakdown kept on a job, under the same names the API
+// uses.
+func scoreResult(imageData ImageDataStore) map[string]interface{} {
+	var result map[string]interface{}
+	if data, err := json.Marshal(imageData); err == nil {
+		json.Unmarshal(data, &result)
+	}
+	return result
+}
+
+
+// deleteStagedImage removes the raw upload the gateway staged, metadata and
+// all. It is not needed once its message has been handled, however that went.
+func deleteStagedImage(staged *storage.ObjectHandle) {
+	if err := staged.Delete(context.Background()); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		log.Printf("Error deleting staged image %s: %v", staged.ObjectName(), err)
+	}
+}
+
+// readStagedImage reads a staged image and checks its size and checksum
+// against the upload message.
+func readStagedImage(staged *storage.ObjectHandle, upload models.ImageUploadMessage) ([]byte, error) {
+	reader, err := staged.NewReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != upload.Size {
+		return nil, fmt.Errorf("size is %d bytes, expected %d", len(data), upload.Size)
+	}
+	checksum := sha256.Sum256(data)
+	if hex.EncodeToString(checksum[:]) != upload.SHA256 {
+		return nil, fmt.Errorf("checksum is %s, expected %s", hex.EncodeToString(checksum[:]), upload.SHA256)
+	}
+	return data, nil
+}
+
+// imageFolder names the folder an image and its renditions are stored in.
+// Uploads always come with a job from the gateway; the fallback keeps stray
+// messages from sharing a folder.
+func imageFolder(jobID string) string {
+	if jobID != "" {
+		return jobID
+	}
+	return fmt.Sprintf("untracked-%d", time.Now().UnixNano())
+}
 
-	ctx := context.Background()
-	_, _, err := utils.FirestoreClient.Collection("images").Add(ctx, imageData)
+// rejectImage fails a job whose image was not accepted, telling the user why
+// with a code and a reason. Other errors fail it without a code.
+func rejectImage(jobID, userID string, err error, updates ...firestore.Update) {
+	code, reason := "", "Error processing image"
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		code, reason = "unsupported_format", "The file is not a JPEG, PNG, GIF or WebP image"
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		code, reason = "resolution_too_high", "The image's resolution is too high"
+	case errors.Is(err, imaging.ErrInvalidImage):
+		code, reason = "invalid_image", "The image is damaged and could not be read"
+	case errors.Is(err, imaging.ErrNoFace):
+		code, reason = "no_face", "No face was found in the image"
+	case errors.Is(err, imaging.ErrMultipleFaces):
+		code, reason = "multiple_faces", "The image shows more than one face"
+	case errors.Is(err, imaging.ErrFaceTooSmall):
+		code, reason = "face_too_small", "The face is too small, take the photo closer"
+	}
+	if code == "" {
+		controllers.FailJob(jobID, userID, reason, updates...)
+		return
+	}
+	controllers.RejectJob(jobID, userID, code, reason, updates...)
+}
+
+// windowNoun words a quota window for the reason given when it is used up.
+var windowNoun = map[string]string{
+	controllers.WindowDaily:   "per day",
+	controllers.WindowWeekly:  "per week",
+	controllers.WindowMonthly: "per month",
+}
+
+// processQuotaCheck answers GET /api/quota with the user's plan and the use
+// of each quota window.
+func processQuotaCheck(msg *sarama.ConsumerMessage) {
+	var check struct {
+		UID string `json:"uid"`
+	}
+	if err := json.Unmarshal(msg.Value, &check); err != nil {
+		log.Printf("Error unmarshalling quota check: %v", err)
+		return
+	}
+
+	response := map[string]interface{}{}
+	plan, windows, err := controllers.QuotaStatus(check.UID, planLimits, time.Now())
 	if err != nil {
-		log.Printf("Error adding image data to Firestore: %v", err)
+		log.Printf("Error getting quota of user %s: %v", check.UID, err)
+		apierror.SetError(response, apierror.New(apierror.CodeInternal, "Error getting quota"))
+	} else {
+		response["plan"] = plan
+		for window, windowStatus := range windows {
+			response[window] = windowStatus
+		}
+		response["statusCode"] = http.StatusOK
 	}
+	produceResponseMessage(response, "quota-check-response", check.UID, replyHeaders(msg))
 }
 
+func produceResponseMessage(response map[string]interface{}, topic, key string, headers []sarama.RecordHeader) {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling response: %v", err)
+		return
+	}
+
+	err = utils.KafkaProducer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(key),
+		Value:   sarama.ByteEncoder(jsonData),
+		Headers: headers,
+	})
+	if err != nil {
+		log.Printf("Error producing message: %v", err)
+	}
+}
+
+// replyHeaders copies the gateway's correlation ID from a request onto its
+// reply so the gateway can match the two.
+func replyHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	for _, header := range msg.Headers {
+		if string(header.Key) == "correlationID" {
+			return []sarama.RecordHeader{{Key: header.Key, Value: header.Value}}
+		}
+	}
+	return nil
+}
+
+// qualityRecord is the quality check's result as kept on jobs and images.
+func qualityRecord(quality imaging.Quality) models.Quality {
+	record := models.Quality{
+		Sharpness:  quality.Sharpness,
+		Brightness: quality.Brightness,
+		Contrast:   quality.Contrast,
+		Resolution: quality.Resolution,
+		FaceSize:   quality.FaceSize,
+		Issues:     []models.QualityIssue{},
+	}
+	for _, issue := range quality.Issues {
+		record.Issues = append(record.Issues, models.QualityIssue{
+			Code:     issue.Code,
+			Severity: issue.Severity,
+			Message:  issue.Message,
+		})
+	}
+	return record
+}
@@ -0,0 +1,11 @@
+package models
+
+// Face is the bounding box of the face in a stored image, in the pixels of
+// its original, with the detector's confidence.
+type Face struct {
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Score  float64 `json:"score"`
+}
@@ -0,0 +1,36 @@
+package models
+
+// Fingerprint holds an image's perceptual hashes, as hex, and the bands they
+// are indexed by (see imaging.HashBands).
+type Fingerprint struct {
+	PHash string   `json:"phash"`
+	DHash string   `json:"dhash"`
+	Bands []string `json:"bands"`
+}
+
+// Kinds of FlagMatch.
+const (
+	// FlagNearDuplicate is another user's image that looks the same.
+	FlagNearDuplicate = "near_duplicate"
+	// FlagBlocklisted is an entry of the operators' image-blocklist.
+	FlagBlocklisted = "blocklisted"
+)
+
+// Review states of an image that was flagged. Images that were never flagged
+// have no review.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// FlagMatch is an image an upload was found to look like. Distance is the
+// smaller Hamming distance of the two perceptual hashes.
+type FlagMatch struct {
+	Kind        string `json:"kind"`
+	ImageId     string `json:"image_id,omitempty"`
+	UserId      string `json:"user_id,omitempty"`
+	BlocklistId string `json:"blocklist_id,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Distance    int    `json:"distance"`
+}
@@ -0,0 +1,51 @@
+package models
+
+// ImageUploadMessage is the value of an image-upload Kafka message. The image
+// itself is not in the message: the gateway stores it in Cloud Storage first
+// and the message points at it, with the size and SHA-256 checksum the
+// image-upload-service checks before using it. The gateway keeps a copy of
+// this type.
+type ImageUploadMessage struct {
+	JobId       string `json:"job_id"`
+	UserId      string `json:"user_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Bucket      string `json:"bucket"`
+	Object      string `json:"object"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// ImageStatus is where an image document is in its lifecycle. An image is
+// uploaded when the image-upload-service picks up its message, normalized
+// once it has been decoded and cleaned, scoring once it has been sent to the
+// image processing service, and ends as scored, rejected when it was turned
+// down, or failed.
+type ImageStatus string
+
+const (
+	ImageUploaded   ImageStatus = "uploaded"
+	ImageNormalized ImageStatus = "normalized"
+	ImageScoring    ImageStatus = "scoring"
+	ImageScored     ImageStatus = "scored"
+	ImageRejected   ImageStatus = "rejected"
+	ImageFailed     ImageStatus = "failed"
+)
+
+// imageTransitions lists the statuses each status may move to. Scored,
+// rejected and failed images stay as they are.
+var imageTransitions = map[ImageStatus][]ImageStatus{
+	ImageUploaded:   {ImageNormalized, ImageRejected, ImageFailed},
+	ImageNormalized: {ImageScoring, ImageRejected, ImageFailed},
+	ImageScoring:    {ImageScored, ImageFailed},
+}
+
+// CanMoveTo reports whether an image may move from s to next.
+func (s ImageStatus) CanMoveTo(next ImageStatus) bool {
+	for _, allowed := range imageTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package models
+
+type JobStatus string
+
+// A scan job moves from queued to stored once the image-upload-service has
+// saved the image, to scoring once it has been handed to the image processing
+// service, and ends as scored or failed.
+const (
+	JobQueued  JobStatus = "queued"
+	JobStored  JobStatus = "stored"
+	JobScoring JobStatus = "scoring"
+	JobScored  JobStatus = "scored"
+	JobFailed  JobStatus = "failed"
+)
+
+// Final reports whether a job has ended. An ended job keeps its status.
+func (s JobStatus) Final() bool {
+	return s == JobScored || s == JobFailed
+}
+
+type Job struct {
+	ID       string    `json:"id"`
+	UserId   string    `json:"user_id"`
+	Status   JobStatus `json:"status"`
+	Filename string    `json:"filename"`
+	ImageKey string    `json:"image_key,omitempty"`
+	// Renditions are the resized copies of the image, by name.
+	Renditions map[string]Rendition `json:"renditions,omitempty"`
+	// ImageDeleted is set by the gateway once the user has deleted the
+	// job's image, together with clearing the image's fields here.
+	ImageDeleted bool `json:"image_deleted,omitempty"`
+	// MetadataRemoved lists the metadata categories scrubbed from the upload.
+	MetadataRemoved []string `json:"metadata_removed,omitempty"`
+	// Face is where the face is in the stored image.
+	Face *Face `json:"face,omitempty"`
+	// Quality is the result of the quality check, including why the image
+	// was rejected or what a retake could improve.
+	Quality *Quality `json:"quality,omitempty"`
+	// ContentHash is the SHA-256 of the normalized image.
+	ContentHash string `json:"content_hash,omitempty"`
+	// Duplicate is set when the user had already had the same image scored
+	// and the job reused that score.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Fingerprint holds the perceptual hashes of the normalized image.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+	// Flags are the other users' images and blocklist entries the image
+	// looks like. A flagged image is held from the leaderboards until it
+	// has been reviewed, which UnderReview tells the user.
+	Flags       []FlagMatch            `json:"flags,omitempty"`
+	UnderReview bool                   `json:"under_review,omitempty"`
+	ImageId     string                 `json:"image_id,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	// ErrorCode says why an image was turned down, for clients to act on.
+	ErrorCode string `json:"error_code,omitempty"`
+	// QuotaChargedAt is when the scan was counted against the user's quota,
+	// so that it can be given back if scoring fails.
+	QuotaChargedAt int64 `json:"quota_charged_at,omitempty"`
+	CreatedAt      int64 `json:"created_at"`
+	UpdatedAt      int64 `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// Quality is what the quality check measured on an image, and the issues it
+// found with it. Issues with severity reject kept the image from being
+// scored; warn issues did not, but a retake would do better.
+type Quality struct {
+	Sharpness  float64        `json:"sharpness"`
+	Brightness float64        `json:"brightness"`
+	Contrast   float64        `json:"contrast"`
+	Resolution int            `json:"resolution"`
+	FaceSize   int            `json:"face_size,omitempty"`
+	Issues     []QualityIssue `json:"issues"`
+}
+
+// QualityIssue is a reason to retake a photo, such as too_dark or
+// face_too_small, with a message that can be shown to the user.
+type QualityIssue struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
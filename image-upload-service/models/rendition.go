@@ -0,0 +1,8 @@
+package models
+
+// Rendition is a resized JPEG of an image, stored next to it in the bucket.
+type Rendition struct {
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"leaderboard-service/apierror"
 	"leaderboard-service/models"
 	"leaderboard-service/utils"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"cloud.google.com/go/firestore"
 	"github.com/IBM/sarama"
@@ -20,8 +26,11 @@ type LeaderBoard struct {
 	ImageResponse ImageDataStore `json:"image_response"`
 }
 
+// ImageDataStore is a scored image. The images collection only holds the
+// private object's ImageKey; ImageURL is a signed URL made for each response.
 type ImageDataStore struct {
-	ImageURL              string  `json:"image_url"`
+	ImageKey              string  `json:"-"`
+	ImageURL              string  `json:"image_url" firestore:"-"`
 	UserId                string  `json:"user_id"`
 	TotalScore            float32 `json:"total_score"`
 	Symmetry              float64 `json:"symmetry"`
@@ -35,6 +44,40 @@ type ImageDataStore struct {
 	LipFullness           float64 `json:"lip_fullness"`
 	FacialFat             float64 `json:"facial_fat"`
 	CompleteFacialHarmony float64 `json:"complete_facial_harmony"`
+	// Review is set on images flagged as looking like another user's or a
+	// blocklisted image: pending until a moderator approves or rejects it.
+	Review string `json:"-"`
+	// Status is scored once the image has its scores. Images scored before
+	// statuses were recorded have none.
+	Status string `json:"-"`
+}
+
+// Review states of a flagged image.
+const (
+	reviewPending  = "pending"
+	reviewRejected = "rejected"
+)
+
+// imageScored is the Status of an image with scores.
+const imageScored = "scored"
+
+const (
+	defaultLeaderboardLimit = 50
+	maxLeaderboardLimit     = 100
+)
+
+// LeaderboardRequest is the body of a leaderboard-male or leaderboard-female
+// message. Cursor is the next_cursor of a previous page.
+type LeaderboardRequest struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+}
+
+// leaderboardCursor marks the last user of a page. It is handed to clients
+// base64 encoded and is opaque to them.
+type leaderboardCursor struct {
+	HighScore float64 `json:"high_score"`
+	UID       string  `json:"uid"`
 }
 
 func main() {
@@ -48,12 +91,35 @@ func main() {
 	utils.InitFirebase()
 	defer utils.CloseFirestore()
 
-	go startKafkaConsumer()
+	utils.InitProducer()
+	defer utils.CloseProducer()
 
-	log.Fatal(app.Listen(":8082"))
+	ctx, stopConsumer := context.WithCancel(context.Background())
+	consumerDone := startKafkaConsumer(ctx)
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":8082"); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+
+	// Stop consuming before the deferred producer close, so that no handler
+	// sends on a closed producer.
+	stopConsumer()
+	<-consumerDone
 }
 
-func startKafkaConsumer() {
+// startKafkaConsumer consumes until ctx is cancelled. The returned channel is
+// closed once the handlers have finished their last messages and the
+// consumer group is closed.
+func startKafkaConsumer(ctx context.Context) <-chan struct{} {
 	brokers := []string{"kafka:9092"}
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
@@ -66,12 +132,18 @@ func startKafkaConsumer() {
 
 	handler := ConsumerGroupHandler{}
 
-	for {
-		err := consumer.Consume(context.Background(), []string{"leaderboard-male", "leaderboard-female"}, handler)
-		if err != nil {
-			log.Printf("Error from consumer: %v", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer consumer.Close()
+		for ctx.Err() == nil {
+			err := consumer.Consume(ctx, []string{"leaderboard-male", "leaderboard-female"}, handler)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error from consumer: %v", err)
+			}
 		}
-	}
+	}()
+	return done
 }
 
 type ConsumerGroupHandler struct{}
@@ -80,24 +152,53 @@ func (ConsumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error   { retur
 func (ConsumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
 func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
+		var request LeaderboardRequest
+		if len(msg.Value) > 0 {
+			if err := json.Unmarshal(msg.Value, &request); err != nil {
+				log.Printf("Error unmarshalling message: %v", err)
+			}
+		}
+
 		switch msg.Topic {
 		case "leaderboard-male":
-			response, statusCode, err := getLeaderboardMale()
-			produceResponseMessage(response, "leaderboard-male-response", "leaderboard-male", statusCode, err)
+			response, statusCode, err := getLeaderboard("Male", request)
+			produceResponseMessage(response, "leaderboard-male-response", "leaderboard-male", statusCode, err, replyHeaders(msg))
 		case "leaderboard-female":
-			response, statusCode, err := getLeaderboardFemale()
-			produceResponseMessage(response, "leaderboard-female-response", "leaderboard-female", statusCode, err)
+			response, statusCode, err := getLeaderboard("Female", request)
+			produceResponseMessage(response, "leaderboard-female-response", "leaderboard-female", statusCode, err, replyHeaders(msg))
 		}
 		sess.MarkMessage(msg, "")
 	}
 	return nil
 }
 
-func getLeaderboardMale() (map[string]interface{}, int, error) {
+// getLeaderboard returns one page of the leaderboard for gender, ordered by
+// high score. Users without a scored image are left out, so a page can hold
+// fewer than limit entries even when more pages follow.
+func getLeaderboard(gender string, request LeaderboardRequest) (map[string]interface{}, int, error) {
 	ctx := context.Background()
-	query := utils.FirestoreClient.Collection("users").Where("Gender", "==", "Male").OrderBy("HighScore", firestore.Desc).Limit(50)
 
-	iter := query.Documents(ctx)
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultLeaderboardLimit
+	}
+	if limit > maxLeaderboardLimit {
+		limit = maxLeaderboardLimit
+	}
+
+	// Ties on high score are broken by UID so the cursor is stable.
+	query := utils.FirestoreClient.Collection("users").Where("Gender", "==", gender).OrderBy("HighScore", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Asc)
+	if request.Cursor != "" {
+		cursor, err := decodeCursor(request.Cursor)
+		if err != nil {
+			log.Printf("Error decoding cursor: %v\n", err)
+			return map[string]interface{}{}, 400, errors.New("invalid cursor")
+		}
+		query = query.StartAfter(cursor.HighScore, cursor.UID)
+	}
+
+	// Fetch one extra user to find out whether there is another page.
+	iter := query.Limit(limit + 1).Documents(ctx)
 	defer iter.Stop()
 
 	var users []models.User
@@ -108,24 +209,27 @@ func getLeaderboardMale() (map[string]interface{}, int, error) {
 		}
 		if err != nil {
 			log.Printf("Error iterating documents: %v\n", err)
-			return nil, 500, err
+			return map[string]interface{}{}, 500, err
 		}
 
 		var user models.User
 		if err := doc.DataTo(&user); err != nil {
 			log.Printf("Error unmarshalling document data: %v\n", err)
-			return nil, 500, err
+			return map[string]interface{}{}, 500, err
 		}
+		user.UID = doc.Ref.ID
 		users = append(users, user)
 		log.Printf("User: %v\n", user)
 	}
 
-	if len(users) == 0 {
-		log.Println("No users found")
-		return nil, 404, nil
+	nextCursor := ""
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[len(users)-1]
+		nextCursor = encodeCursor(leaderboardCursor{HighScore: last.HighScore, UID: last.UID})
 	}
 
-	var leaderboard []LeaderBoard
+	leaderboard := []LeaderBoard{}
 	for _, user := range users {
 		processUserImage(ctx, &leaderboard, user)
 	}
@@ -133,56 +237,38 @@ func getLeaderboardMale() (map[string]interface{}, int, error) {
 	response := map[string]interface{}{
 		"leaderboard": leaderboard,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 	log.Printf("Leaderboard: %v\n", leaderboard)
 	return response, 200, nil
 }
 
-func getLeaderboardFemale() (map[string]interface{}, int, error) {
-	ctx := context.Background()
-	query := utils.FirestoreClient.Collection("users").Where("Gender", "==", "Female").OrderBy("HighScore", firestore.Desc).Limit(50)
-
-	iter := query.Documents(ctx)
-	defer iter.Stop()
-
-	var users []models.User
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("Error iterating documents: %v\n", err)
-			return nil, 500, err
-		}
+func encodeCursor(cursor leaderboardCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
 
-		var user models.User
-		if err := doc.DataTo(&user); err != nil {
-			log.Printf("Error unmarshalling document data: %v\n", err)
-			return nil, 500, err
-		}
-		users = append(users, user)
-		log.Printf("User: %v\n", user)
+func decodeCursor(encoded string) (leaderboardCursor, error) {
+	var cursor leaderboardCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
 	}
-
-	if len(users) == 0 {
-		log.Println("No users found")
-		return nil, 404, nil
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
 	}
-
-	var leaderboard []LeaderBoard
-	for _, user := range users {
-		processUserImage(ctx, &leaderboard, user)
+	if cursor.UID == "" {
+		return cursor, errors.New("cursor has no uid")
 	}
-
-	response := map[string]interface{}{
-		"leaderboard": leaderboard,
-	}
-	log.Printf("Leaderboard: %v\n", leaderboard)
-	return response, 200, nil
+	return cursor, nil
 }
 
+// processUserImage adds the user with their best image. Flagged images are
+// not shown while they await review, rejected images and images that were
+// not scored are never shown, and the user's best remaining image is used.
 func processUserImage(ctx context.Context, leaderboard *[]LeaderBoard, user models.User) {
-	imageQuery := utils.FirestoreClient.Collection("images").Where("UserId", "==", user.UID).OrderBy("TotalScore", firestore.Desc).Limit(1)
+	imageQuery := utils.FirestoreClient.Collection("images").Where("UserId", "==", user.UID).OrderBy("TotalScore", firestore.Desc)
 	imageIter := imageQuery.Documents(ctx)
 	defer imageIter.Stop()
 
@@ -202,21 +288,36 @@ func processUserImage(ctx context.Context, leaderboard *[]LeaderBoard, user mode
 			log.Printf("Error unmarshalling image data: %v", err)
 			return
 		}
+		if imageDataStore.Review == reviewPending || imageDataStore.Review == reviewRejected ||
+			(imageDataStore.Status != "" && imageDataStore.Status != imageScored) {
+			continue
+		}
 
 		log.Printf("Successfully fetched image data for user ID: %s, Total Score: %f", user.UID, imageDataStore.TotalScore)
+		imageURL, err := utils.SignedImageURL(imageDataStore.ImageKey)
+		if err != nil {
+			log.Printf("Error signing image URL for user %s: %v", user.UID, err)
+		}
+		imageDataStore.ImageURL = imageURL
 		log.Printf("Total score for user %s: %f", user.Username, imageDataStore.TotalScore)
 
 		*leaderboard = append(*leaderboard, LeaderBoard{
 			Username:      user.Username,
 			ImageResponse: imageDataStore,
 		})
+		return
 	}
 }
 
-func produceResponseMessage(response map[string]interface{}, topic, key string, statusCode int, err error) {
+func produceResponseMessage(response map[string]interface{}, topic, key string, statusCode int, err error, headers []sarama.RecordHeader) {
 	response["statusCode"] = statusCode
 	if err != nil {
-		response["error"] = err.Error()
+		// Only client errors are explained; the rest stay in the log.
+		message := "Error getting leaderboard"
+		if statusCode < 500 {
+			message = err.Error()
+		}
+		apierror.SetError(response, apierror.FromStatus(statusCode, message))
 	}
 
 	jsonData, err := json.Marshal(response)
@@ -226,21 +327,25 @@ func produceResponseMessage(response map[string]interface{}, topic, key string,
 	}
 
 	kafkaMessage := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(jsonData),
+		Topic:   topic,
+		Key:     sarama.StringEncoder(key),
+		Value:   sarama.ByteEncoder(jsonData),
+		Headers: headers,
 	}
 
-	producer, err := sarama.NewSyncProducer([]string{"kafka:9092"}, nil)
+	err = utils.KafkaProducer.SendMessage(kafkaMessage)
 	if err != nil {
-		log.Printf("Error creating producer: %v", err)
-		return
+		log.Printf("Error producing message: %v", err)
 	}
+}
 
-	defer producer.Close()
-
-	_, _, err = producer.SendMessage(kafkaMessage)
-	if err != nil {
-		log.Printf("Error producing message: %v", err)
+// replyHeaders copies the gateway's correlation ID from a request onto its
+// reply so the gateway can match the two.
+func replyHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	for _, header := range msg.Headers {
+		if string(header.Key) == "correlationID" {
+			return []sarama.RecordHeader{{Key: header.Key, Value: header.Value}}
+		}
 	}
+	return nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, cursor := range []leaderboardCursor{
+		{HighScore: 87.25, UID: "user-1"},
+		{HighScore: 0, UID: "a/b+c=="},
+	} {
+		encoded := encodeCursor(cursor)
+		decoded, err := decodeCursor(encoded)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q) error = %v", encoded, err)
+		}
+		if decoded != cursor {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", cursor, decoded)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsBadInput(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	for _, encoded := range []string{
+		"",
+		"not base64!",
+		encode("not json"),
+		encode(`{"high_score": 10}`),
+		encode(`{"high_score": "ten", "uid": "user-1"}`),
+	} {
+		if cursor, err := decodeCursor(encoded); err == nil {
+			t.Errorf("decodeCursor(%q) = %+v, want an error", encoded, cursor)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// ProducerConfig controls how the service's Kafka producer delivers messages.
+type ProducerConfig struct {
+	Brokers []string
+	// Async producers queue messages and report delivery failures in the log
+	// only; sync producers wait for every message to be acknowledged.
+	Async       bool
+	Acks        sarama.RequiredAcks
+	Compression sarama.CompressionCodec
+	// Idempotent producers let the broker drop duplicates caused by retries.
+	// It requires Acks to be sarama.WaitForAll.
+	Idempotent bool
+}
+
+// KafkaProducer is the service's long-lived producer, set up by InitProducer.
+var KafkaProducer *Producer
+
+// Producer wraps a sync or async sarama producer. It is created once at
+// startup and is safe for concurrent use.
+type Producer struct {
+	syncProducer  sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	done          sync.WaitGroup
+}
+
+// ProducerConfigFromEnv reads the producer settings from KAFKA_BROKERS,
+// KAFKA_PRODUCER_MODE (sync or async), KAFKA_PRODUCER_ACKS (none, leader or
+// all), KAFKA_PRODUCER_COMPRESSION (none, gzip, snappy, lz4 or zstd) and
+// KAFKA_PRODUCER_IDEMPOTENT.
+func ProducerConfigFromEnv() ProducerConfig {
+	config := ProducerConfig{
+		Brokers: []string{"kafka:9092"},
+		Acks:    sarama.WaitForLocal,
+	}
+	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+		config.Brokers = strings.Split(brokers, ",")
+	}
+	config.Async = os.Getenv("KAFKA_PRODUCER_MODE") == "async"
+
+	switch os.Getenv("KAFKA_PRODUCER_ACKS") {
+	case "none":
+		config.Acks = sarama.NoResponse
+	case "all":
+		config.Acks = sarama.WaitForAll
+	}
+
+	switch os.Getenv("KAFKA_PRODUCER_COMPRESSION") {
+	case "gzip":
+		config.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Compression = sarama.CompressionLZ4
+	case "zstd":
+		config.Compression = sarama.CompressionZSTD
+	}
+
+	if os.Getenv("KAFKA_PRODUCER_IDEMPOTENT") == "true" {
+		config.Idempotent = true
+		config.Acks = sarama.WaitForAll
+	}
+	return config
+}
+
+func NewProducer(config ProducerConfig) (*Producer, error) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.RequiredAcks = config.Acks
+	saramaConfig.Producer.Compression = config.Compression
+	if config.Compression == sarama.CompressionZSTD {
+		saramaConfig.Version = sarama.V2_1_0_0
+	}
+	if config.Idempotent {
+		saramaConfig.Producer.Idempotent = true
+		saramaConfig.Net.MaxOpenRequests = 1
+		if !saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+			saramaConfig.Version = sarama.V0_11_0_0
+		}
+	}
+
+	producer := &Producer{}
+	if !config.Async {
+		saramaConfig.Producer.Return.Successes = true
+		syncProducer, err := sarama.NewSyncProducer(config.Brokers, saramaConfig)
+		if err != nil {
+			return nil, err
+		}
+		producer.syncProducer = syncProducer
+		return producer, nil
+	}
+
+	saramaConfig.Producer.Return.Errors = true
+	asyncProducer, err := sarama.NewAsyncProducer(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	producer.asyncProducer = asyncProducer
+	producer.done.Add(1)
+	go func() {
+		defer producer.done.Done()
+		for err := range asyncProducer.Errors() {
+			log.Printf("Error producing message to topic %s: %v", err.Msg.Topic, err.Err)
+		}
+	}()
+	return producer, nil
+}
+
+// SendMessage sends msg. Sync producers return once the message is stored;
+// async producers return as soon as it is queued.
+func (p *Producer) SendMessage(msg *sarama.ProducerMessage) error {
+	if p.asyncProducer != nil {
+		p.asyncProducer.Input() <- msg
+		return nil
+	}
+
+	partition, offset, err := p.syncProducer.SendMessage(msg)
+	if err != nil {
+		return err
+	}
+	log.Printf("Message is stored in topic(%s)/partition(%d)/offset(%d)", msg.Topic, partition, offset)
+	return nil
+}
+
+// Close flushes any queued messages and closes the producer.
+func (p *Producer) Close() error {
+	if p.asyncProducer != nil {
+		p.asyncProducer.AsyncClose()
+		p.done.Wait()
+		return nil
+	}
+	return p.syncProducer.Close()
+}
+
+func InitProducer() {
+	var err error
+	KafkaProducer, err = NewProducer(ProducerConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error creating kafka producer: %v", err)
+	}
+}
+
+func CloseProducer() {
+	if KafkaProducer != nil {
+		if err := KafkaProducer.Close(); err != nil {
+			log.Printf("Error closing kafka producer: %v", err)
+		}
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"user-management-service/models"
 	"user-management-service/utils"
 
+	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func HandleUserProfileUpdate(user models.User) (int, string) {
@@ -29,11 +32,11 @@ func HandleUserProfileUpdate(user models.User) (int, string) {
 		return http.StatusInternalServerError, "Error unmarshalling user data from Firestore"
 	}
 
-	// Update the user document
+	// Update the user document. The high score is only ever set from scan
+	// results, so it is kept.
 	existingUser.Username = user.Username
 	existingUser.Age = user.Age
 	existingUser.Gender = user.Gender
-	existingUser.HighScore = user.HighScore
 
 	if _, err := docRef.Set(context.Background(), existingUser); err != nil {
 		log.Println("Error updating user document:", err)
@@ -100,4 +103,45 @@ func UpdateUserHighScore(uid string, highScore float64) error {
 
 	log.Printf("High score updated successfully for UID: %s\n", uid)
 	return nil
-}
\ No newline at end of file
+}
+
+// JobUnderReview reports whether a scan job's image was flagged for review.
+// Its score must not count toward the user's high score until a moderator
+// approves it. Jobs that do not exist are not under review.
+func JobUnderReview(jobID string) (bool, error) {
+	if jobID == "" {
+		return false, nil
+	}
+	doc, err := utils.FirestoreClient.Collection("jobs").Doc(jobID).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var job struct {
+		UnderReview bool
+	}
+	if err := doc.DataTo(&job); err != nil {
+		return false, err
+	}
+	return job.UnderReview, nil
+}
+
+// HandlePlanAssignment changes a user's plan, which sets how many scans the
+// image-upload-service lets them run. The request must already be valid.
+func HandlePlanAssignment(request models.PlanAssignment) (int, string) {
+	_, err := utils.FirestoreClient.Collection("users").Doc(request.UID).Update(context.Background(), []firestore.Update{
+		{Path: "Plan", Value: request.Plan},
+	})
+	if status.Code(err) == codes.NotFound {
+		return http.StatusNotFound, "User not found"
+	}
+	if err != nil {
+		log.Printf("Error setting plan for user %s: %v", request.UID, err)
+		return http.StatusInternalServerError, "Error setting plan"
+	}
+
+	log.Printf("User %s set plan of %s to %s", request.AssignedBy, request.UID, request.Plan)
+	return http.StatusOK, "Plan updated successfully"
+}
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"user-management-service/apierror"
 	"user-management-service/controllers"
 	"user-management-service/models"
 	"user-management-service/utils"
+	"user-management-service/validation"
 
 	"github.com/IBM/sarama"
 	"github.com/gofiber/fiber/v2"
@@ -25,6 +30,9 @@ func main() {
     utils.InitFirebase()
     defer utils.CloseFirestore()
 
+    utils.InitProducer()
+    defer utils.CloseProducer()
+
     app := fiber.New()
 
     app.Use(cors.New(cors.Config{
@@ -33,12 +41,32 @@ func main() {
         AllowCredentials: true,
     }))
 
-    go startKafkaConsumer()
+    ctx, stopConsumer := context.WithCancel(context.Background())
+    consumerDone := startKafkaConsumer(ctx)
+
+    go func() {
+        sigs := make(chan os.Signal, 1)
+        signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+        <-sigs
+        if err := app.Shutdown(); err != nil {
+            log.Printf("Error shutting down server: %v", err)
+        }
+    }()
+
+    if err := app.Listen(":8081"); err != nil {
+        log.Fatalf("Error starting server: %v", err)
+    }
 
-    log.Fatal(app.Listen(":8081"))
+    // Stop consuming before the deferred producer close, so that no handler
+    // sends on a closed producer.
+    stopConsumer()
+    <-consumerDone
 }
 
-func startKafkaConsumer() {
+// startKafkaConsumer consumes until ctx is cancelled. The returned channel is
+// closed once the handlers have finished their last messages and the
+// consumer group is closed.
+func startKafkaConsumer(ctx context.Context) <-chan struct{} {
     brokers := []string{"kafka:9092"}
     config := sarama.NewConfig()
     config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
@@ -51,12 +79,18 @@ func startKafkaConsumer() {
 
     handler := ConsumerGroupHandler{}
 
-    for {
-        err := consumer.Consume(context.Background(), []string{"user-profile-update", "username-check"}, handler)
-        if err != nil {
-            log.Printf("Error from consumer: %v", err)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        defer consumer.Close()
+        for ctx.Err() == nil {
+            err := consumer.Consume(ctx, []string{"user-profile-update", "username-check", "user-plan-assign"}, handler)
+            if err != nil && ctx.Err() == nil {
+                log.Printf("Error from consumer: %v", err)
+            }
         }
-    }
+    }()
+    return done
 }
 
 type ConsumerGroupHandler struct{}
@@ -73,17 +107,19 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 				log.Printf("Error unmarshalling message: %v", err)
 				continue
 			}
-			statusCode, responseMessage := controllers.HandleUserProfileUpdate(user)
-			if statusCode != http.StatusOK {
-				log.Printf("Error updating user profile: %v", responseMessage)
-				continue
-			}
 			response := map[string]interface{}{
-				"message":    responseMessage,
-				"email":      user.Email,
-				"statusCode": statusCode,
+				"email": user.Email,
 			}
-			produceResponseMessage(response, "user-profile-update-response", user.Email)
+			if errs := validation.Validate(user); len(errs) > 0 {
+				apierror.SetError(response, apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs))
+			} else {
+				statusCode, responseMessage := controllers.HandleUserProfileUpdate(user)
+				if statusCode != http.StatusOK {
+					log.Printf("Error updating user profile: %v", responseMessage)
+				}
+				apierror.SetReply(response, statusCode, responseMessage)
+			}
+			produceResponseMessage(response, "user-profile-update-response", user.Email, replyHeaders(msg))
 			sess.MarkMessage(msg, "")
 		case "username-check":
 			var check struct {
@@ -94,21 +130,42 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 				log.Printf("Error unmarshalling message: %v", err)
 				continue
 			}
+			response := map[string]interface{}{
+				"uid": check.UID,
+			}
 			hasUsername, err := controllers.CheckUserHasUsername(check.UID)
 			if err != nil {
 				log.Printf("Error checking username: %v", err)
+				apierror.SetError(response, apierror.New(apierror.CodeInternal, "Error checking username"))
+			} else {
+				response["hasUsername"] = hasUsername
+				response["statusCode"] = http.StatusOK
+			}
+			produceResponseMessage(response, "username-check-response", check.UID, replyHeaders(msg))
+			sess.MarkMessage(msg, "")
+		case "user-plan-assign":
+			var request models.PlanAssignment
+			err := json.Unmarshal(msg.Value, &request)
+			if err != nil {
+				log.Printf("Error unmarshalling message: %v", err)
 				continue
 			}
 			response := map[string]interface{}{
-				"hasUsername": hasUsername,
-				"uid":         check.UID,
-				"statusCode":  http.StatusOK,
+				"uid":  request.UID,
+				"plan": request.Plan,
 			}
-			produceResponseMessage(response, "username-check-response", check.UID)
+			if errs := validation.Validate(request); len(errs) > 0 {
+				apierror.SetError(response, apierror.New(apierror.CodeValidationFailed, "Validation failed").WithDetails(errs))
+			} else {
+				statusCode, responseMessage := controllers.HandlePlanAssignment(request)
+				apierror.SetReply(response, statusCode, responseMessage)
+			}
+			produceResponseMessage(response, "user-plan-assign-response", request.UID, replyHeaders(msg))
 			sess.MarkMessage(msg, "")
 		case "image-processing-response":
 			var imageResponse struct {
 				UserId     string  `json:"user_id"`
+				JobId      string  `json:"job_id"`
 				TotalScore float64 `json:"total_score"`
 			}
 			err := json.Unmarshal(msg.Value, &imageResponse)
@@ -116,6 +173,18 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 				log.Printf("Error unmarshalling message: %v", err)
 				continue
 			}
+			// Flagged images count once a moderator approves them, when the
+			// gateway recomputes the high score.
+			underReview, err := controllers.JobUnderReview(imageResponse.JobId)
+			if err != nil {
+				log.Printf("Error getting job %s: %v", imageResponse.JobId, err)
+				continue
+			}
+			if underReview {
+				log.Printf("Job %s is under review, leaving the high score of user %s", imageResponse.JobId, imageResponse.UserId)
+				sess.MarkMessage(msg, "")
+				continue
+			}
 			err = controllers.UpdateUserHighScore(imageResponse.UserId, imageResponse.TotalScore)
 			if err != nil {
 				log.Printf("Error updating user high score: %v", err)
@@ -126,7 +195,7 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 				"userId":     imageResponse.UserId,
 				"statusCode": http.StatusOK,
 			}
-			produceResponseMessage(response, "high-score-update-response", imageResponse.UserId)
+			produceResponseMessage(response, "high-score-update-response", imageResponse.UserId, nil)
 			sess.MarkMessage(msg, "")
 		}
 	}
@@ -134,14 +203,7 @@ func (h ConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, cla
 }
 
 
-func produceResponseMessage(response map[string]interface{}, topic, key string) {
-	producer, err := sarama.NewSyncProducer([]string{"kafka:9092"}, nil)
-	if err != nil {
-		log.Printf("Error creating producer: %v", err)
-		return
-	}
-	defer producer.Close()
-
+func produceResponseMessage(response map[string]interface{}, topic, key string, headers []sarama.RecordHeader) {
 	msg, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling response: %v", err)
@@ -152,10 +214,22 @@ func produceResponseMessage(response map[string]interface{}, topic, key string)
 		Topic: topic,
 		Value: sarama.StringEncoder(msg),
 		Key:   sarama.StringEncoder(key),
+		Headers: headers,
 	}
 
-	_, _, err = producer.SendMessage(kafkaMsg)
+	err = utils.KafkaProducer.SendMessage(kafkaMsg)
 	if err != nil {
 		log.Printf("Error producing message: %v", err)
 	}
 }
+
+// replyHeaders copies the gateway's correlation ID from a request onto its
+// reply so the gateway can match the two.
+func replyHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	for _, header := range msg.Headers {
+		if string(header.Key) == "correlationID" {
+			return []sarama.RecordHeader{{Key: header.Key, Value: header.Value}}
+		}
+	}
+	return nil
+}
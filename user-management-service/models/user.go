@@ -1,12 +1,23 @@
 package models
 
+// User is a stored user. Its validate rules are those of a profile update and
+// match the gateway's models.ProfileUpdate.
 type User struct {
-	UID			string  `json:"uid"`
-	Email		string 	`json:"email"`
-	Username	string 	`json:"username"`
+	UID			string  `json:"uid" validate:"readonly,required"`
+	Email		string 	`json:"email" validate:"email,max=254"`
+	Username	string 	`json:"username" validate:"required,min=3,max=30,username"`
 	Password	string 	`json:"password"`
-	Age			int	   	`json:"age"`
-	Gender		string 	`json:"gender"`
-	HighScore	float64	`json:"high_score"`
-	CreatedAt	int64	`json:"created_at"`
+	Age			int	   	`json:"age" validate:"required,min=13,max=120"`
+	Gender		string 	`json:"gender" validate:"required,oneof=Male Female"`
+	HighScore	float64	`json:"high_score" validate:"readonly"`
+	CreatedAt	int64	`json:"created_at" validate:"readonly"`
+	Plan		string	`json:"plan" validate:"readonly"`
+}
+
+// PlanAssignment asks for a user's plan to be changed. Its rules match the
+// gateway's models.PlanAssignment.
+type PlanAssignment struct {
+	UID        string `json:"uid" validate:"required"`
+	Plan       string `json:"plan" validate:"required,oneof=free plus pro"`
+	AssignedBy string `json:"assigned_by" validate:"readonly,required"`
 }
\ No newline at end of file